@@ -6,22 +6,71 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/syncromatics/idl-repository/internal/compatibility"
 	"github.com/syncromatics/idl-repository/internal/repository"
 	"github.com/syncromatics/idl-repository/internal/storage"
 
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	goversion "go.hein.dev/go-version"
 	"golang.org/x/sync/errgroup"
 )
 
 var (
-	port            *int
-	storageDiretory *string
+	port              *int
+	storageDiretory   *string
+	adminToken        *string
+	authConfig        *string
+	storageDriver     *string
+	s3Bucket          *string
+	s3Prefix          *string
+	s3Endpoint        *string
+	s3Region          *string
+	s3Timeout         *time.Duration
+	compatibilityMode *string
+	maxFileSize       *int64
+	maxArchiveSize    *int64
+	maxBodySize       *int64
+	readTimeout       *time.Duration
+	readHeaderTimeout *time.Duration
+	writeTimeout      *time.Duration
+	idleTimeout       *time.Duration
+	shutdownTimeout   *time.Duration
+	tlsCert           *string
+	tlsKey            *string
+	tlsClientCA       *string
+	metricsPort       *int
+	logFormat         *string
 )
 
 func init() {
 	port = RootCmd.Flags().IntP("port", "p", 80, "The port to host the server on")
 	storageDiretory = RootCmd.Flags().StringP("storage", "s", ".idl", "The storage location for modules")
+	adminToken = RootCmd.Flags().String("admin-token", "", "The bearer token that allows forcing overwrites of published versions, $IDL_ADMIN_TOKEN is used when not set")
+	authConfig = RootCmd.Flags().String("auth-config", "", "A yaml file with tokens, jwt settings and project permissions, without it anyone can push")
+	storageDriver = RootCmd.Flags().String("storage-driver", "file", "Where modules are stored, either 'file' or 's3'")
+	s3Bucket = RootCmd.Flags().String("s3-bucket", "", "The bucket to store modules in when using the s3 storage driver")
+	s3Prefix = RootCmd.Flags().String("s3-prefix", "", "The key prefix to store modules under when using the s3 storage driver")
+	s3Endpoint = RootCmd.Flags().String("s3-endpoint", "", "The endpoint of an S3 compatible service, defaults to AWS for the region")
+	s3Region = RootCmd.Flags().String("s3-region", "us-east-1", "The region of the bucket when using the s3 storage driver")
+	s3Timeout = RootCmd.Flags().Duration("s3-timeout", time.Minute, "How long connecting to the S3 service and each request to it may take before it is abandoned")
+	maxFileSize = RootCmd.Flags().Int64("max-file-size", 16<<20, "The largest uncompressed file in bytes an uploaded archive may contain, 0 for no limit")
+	maxArchiveSize = RootCmd.Flags().Int64("max-archive-size", 128<<20, "The largest total uncompressed size in bytes of an uploaded archive, 0 for no limit")
+	maxBodySize = RootCmd.Flags().Int64("max-body-size", 128<<20, "The largest request body in bytes, larger uploads are answered with 413, 0 for no limit")
+	readTimeout = RootCmd.Flags().Duration("read-timeout", 5*time.Minute, "How long a client may take to send a whole request including its body, 0 for no limit")
+	readHeaderTimeout = RootCmd.Flags().Duration("read-header-timeout", 10*time.Second, "How long a client may take to send request headers")
+	writeTimeout = RootCmd.Flags().Duration("write-timeout", 5*time.Minute, "How long writing a response may take, 0 for no limit")
+	idleTimeout = RootCmd.Flags().Duration("idle-timeout", 2*time.Minute, "How long an idle keep-alive connection is kept open")
+	shutdownTimeout = RootCmd.Flags().Duration("shutdown-timeout", 30*time.Second, "How long requests in flight get to finish after SIGINT or SIGTERM")
+	tlsCert = RootCmd.Flags().String("tls-cert", "", "A PEM certificate to serve https with, reloaded when the file changes")
+	tlsKey = RootCmd.Flags().String("tls-key", "", "The PEM private key of --tls-cert, reloaded when the file changes")
+	tlsClientCA = RootCmd.Flags().String("tls-client-ca", "", "A PEM CA bundle, when set clients must present a certificate signed by it")
+	metricsPort = RootCmd.Flags().Int("metrics-port", 0, "Serve prometheus metrics, /healthz and /readyz over plain http on this port instead of only on --port")
+	logFormat = RootCmd.Flags().String("log-format", "logfmt", "How request and audit logs are written to stderr, either 'logfmt' or 'json'")
+	compatibilityMode = RootCmd.Flags().String("compatibility", "none", "The schema compatibility mode for projects without their own setting, one of none, backward, forward or full")
 }
 
 var RootCmd = &cobra.Command{
@@ -30,10 +79,53 @@ var RootCmd = &cobra.Command{
 	Long:  `long explanation here`,
 	Run: func(cmd *cobra.Command, args []string) {
 		settings := &repository.Settings{
-			Port: *port,
+			Port:           *port,
+			AdminToken:     *adminToken,
+			MaxFileSize:    *maxFileSize,
+			MaxArchiveSize: *maxArchiveSize,
+			MaxBodySize:    *maxBodySize,
+
+			ReadTimeout:       *readTimeout,
+			ReadHeaderTimeout: *readHeaderTimeout,
+			WriteTimeout:      *writeTimeout,
+			IdleTimeout:       *idleTimeout,
+			ShutdownTimeout:   *shutdownTimeout,
+
+			TLSCert:     *tlsCert,
+			TLSKey:      *tlsKey,
+			TLSClientCA: *tlsClientCA,
+
+			MetricsPort: *metricsPort,
+
+			Build: goversion.New(version, commit, date),
+		}
+
+		logger, err := repository.NewLogger(*logFormat)
+		if err != nil {
+			panic(err)
+		}
+		settings.Log = logger
+
+		// read at run time so the token never shows up in --help or the docs
+		if settings.AdminToken == "" {
+			settings.AdminToken = os.Getenv("IDL_ADMIN_TOKEN")
+		}
+
+		mode, err := compatibility.ParseMode(*compatibilityMode)
+		if err != nil {
+			panic(err)
+		}
+		settings.DefaultCompatibility = mode
+
+		if *authConfig != "" {
+			auth, err := loadAuthSettings(*authConfig)
+			if err != nil {
+				panic(err)
+			}
+			settings.Auth = auth
 		}
 
-		storage, err := storage.NewFileStorage(*storageDiretory)
+		storage, err := newStorage(logger)
 		if err != nil {
 			panic(err)
 		}
@@ -58,6 +150,36 @@ var RootCmd = &cobra.Command{
 	},
 }
 
+func loadAuthSettings(location string) (*repository.AuthSettings, error) {
+	f, err := os.Open(location)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open auth config")
+	}
+	defer f.Close()
+
+	return repository.LoadAuthSettings(f)
+}
+
+func newStorage(log logrus.FieldLogger) (repository.Storage, error) {
+	switch *storageDriver {
+	case "file":
+		return storage.NewFileStorage(*storageDiretory, log)
+	case "s3":
+		return storage.NewS3Storage(storage.S3Settings{
+			Endpoint:     *s3Endpoint,
+			Region:       *s3Region,
+			Bucket:       *s3Bucket,
+			Prefix:       *s3Prefix,
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			Timeout:      *s3Timeout,
+		})
+	}
+
+	return nil, errors.New(fmt.Sprintf("unknown storage driver '%s'", *storageDriver))
+}
+
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
 		fmt.Println(err)
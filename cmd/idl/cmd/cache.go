@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/syncromatics/idl-repository/pkg/cache"
+
+	"github.com/spf13/cobra"
+)
+
+var olderThan time.Duration
+
+func init() {
+	pruneCommand.Flags().DurationVar(&olderThan, "older-than", 30*24*time.Hour, "Remove archives that have not been used for this long")
+	cacheCommand.AddCommand(listCacheCommand, pruneCommand, cleanCommand)
+	RootCmd.AddCommand(cacheCommand)
+}
+
+var cacheCommand = &cobra.Command{
+	Use:   "cache",
+	Short: "manage the dependencies downloaded to this machine",
+	Long:  "Dependencies are kept in $XDG_CACHE_HOME/idl (~/.cache/idl by default) and shared by every project on the machine",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var listCacheCommand = &cobra.Command{
+	Use:   "list",
+	Short: "list the cached dependencies",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := openCache(cmd)
+
+		entries, err := c.List()
+		if err != nil {
+			cmd.PrintErrln(err)
+			os.Exit(1)
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "REPOSITORY\tNAME\tTYPE\tVERSION\tSIZE\tLAST USED")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", e.Repository, e.Name, e.Type, e.Version, e.Size, e.Used.Format(time.RFC3339))
+		}
+		w.Flush()
+	},
+}
+
+var pruneCommand = &cobra.Command{
+	Use:   "prune",
+	Short: "remove cached dependencies that have not been used recently",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := openCache(cmd)
+
+		freed, err := c.Prune(time.Now().Add(-olderThan))
+		if err != nil {
+			cmd.PrintErrln(err)
+			os.Exit(1)
+			return
+		}
+
+		fmt.Printf("freed %d bytes\n", freed)
+	},
+}
+
+var cleanCommand = &cobra.Command{
+	Use:   "clean",
+	Short: "remove every cached dependency",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := openCache(cmd)
+
+		err := c.Clean()
+		if err != nil {
+			cmd.PrintErrln(err)
+			os.Exit(1)
+			return
+		}
+	},
+}
+
+func openCache(cmd *cobra.Command) *cache.Cache {
+	c, err := cache.Default()
+	if err != nil {
+		cmd.PrintErrln(err)
+		os.Exit(1)
+	}
+	return c
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/syncromatics/idl-repository/pkg/client"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	deprecatedVersion *semver.Version
+	deprecateType     string
+	deprecateMessage  string
+	replacement       string
+)
+
+func init() {
+	deprecateCommand.Flags().StringVar(&deprecateType, "type", "", "Deprecate this type, or only this type of the version")
+	deprecateCommand.Flags().StringVar(&deprecateMessage, "message", "", "Why the project, type or version should no longer be used")
+	deprecateCommand.Flags().StringVar(&replacement, "replacement", "", "What to use instead, like 'example/v2'")
+	undeprecateCommand.Flags().StringVar(&deprecateType, "type", "", "Undeprecate this type, or only this type of the version")
+	RootCmd.AddCommand(deprecateCommand)
+	RootCmd.AddCommand(undeprecateCommand)
+}
+
+func deprecateArgs(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 {
+		return errors.New("accepts at most a version")
+	}
+
+	deprecatedVersion = nil
+	if len(args) == 1 {
+		var err error
+		deprecatedVersion, err = semver.NewVersion(args[0])
+		if err != nil {
+			return errors.Wrap(err, "invalid version")
+		}
+	}
+
+	err := initConfig()
+	if err != nil {
+		return errors.Wrap(err, "invalid config")
+	}
+
+	return nil
+}
+
+var deprecateCommand = &cobra.Command{
+	Use:   "deprecate [version]",
+	Short: "mark the project, a type or a version as deprecated",
+	Long:  "Without a version or --type the whole project is deprecated. Pulling a deprecated dependency prints a warning, or fails with --strict.",
+	Args:  deprecateArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := client.Deprecate(client.DeprecateOptions{
+			Configuration: configuration,
+			Version:       deprecatedVersion,
+			Type:          deprecateType,
+			Message:       deprecateMessage,
+			Replacement:   replacement,
+		})
+		if err != nil {
+			cmd.PrintErrln(err)
+			os.Exit(1)
+			return
+		}
+	},
+}
+
+var undeprecateCommand = &cobra.Command{
+	Use:   "undeprecate [version]",
+	Short: "remove a deprecation",
+	Long:  "Removes the deprecation deprecate set with the same version and --type.",
+	Args:  deprecateArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := client.Undeprecate(client.DeprecateOptions{
+			Configuration: configuration,
+			Version:       deprecatedVersion,
+			Type:          deprecateType,
+		})
+		if err != nil {
+			cmd.PrintErrln(err)
+			os.Exit(1)
+			return
+		}
+	},
+}
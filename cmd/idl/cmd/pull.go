@@ -3,13 +3,32 @@ package cmd
 import (
 	"os"
 
+	"github.com/syncromatics/idl-repository/pkg/cache"
 	"github.com/syncromatics/idl-repository/pkg/client"
+	"github.com/syncromatics/idl-repository/pkg/config"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
+const lockLocation = "idl.lock"
+
+var (
+	update  bool
+	maxSize int64
+	jobs    int
+	offline bool
+	noCache bool
+	strict  bool
+)
+
 func init() {
+	pullCommand.Flags().BoolVar(&update, "update", false, "Ignore the lock file and resolve dependencies to their newest matching versions")
+	pullCommand.Flags().Int64Var(&maxSize, "max-size", client.DefaultMaxSize, "The largest size in bytes a dependency may download or unpack to")
+	pullCommand.Flags().IntVarP(&jobs, "jobs", "j", client.DefaultJobs, "How many dependencies to download at the same time")
+	pullCommand.Flags().BoolVar(&offline, "offline", false, "Only use dependencies already in the local cache")
+	pullCommand.Flags().BoolVar(&noCache, "no-cache", false, "Do not read or write the local cache")
+	pullCommand.Flags().BoolVar(&strict, "strict", false, "Fail instead of warning when a dependency is deprecated")
 	RootCmd.AddCommand(pullCommand)
 }
 
@@ -26,13 +45,73 @@ var pullCommand = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		err := client.Pull(client.PullOptions{
+		lock, err := readLock()
+		if err != nil {
+			cmd.PrintErrln(err)
+			os.Exit(1)
+			return
+		}
+
+		var c *cache.Cache
+		if !noCache {
+			c = openCache(cmd)
+		}
+
+		lock, err = client.Pull(client.PullOptions{
 			Configuration: configuration,
+			Lock:          lock,
+			Update:        update,
+			MaxSize:       maxSize,
+			Jobs:          jobs,
+			Cache:         c,
+			Offline:       offline,
+			Strict:        strict,
+			Warn: func(message string) {
+				cmd.PrintErrln("warning: " + message)
+			},
 		})
 		if err != nil {
 			cmd.PrintErrln(err)
 			os.Exit(1)
 			return
 		}
+
+		err = writeLock(lock)
+		if err != nil {
+			cmd.PrintErrln(err)
+			os.Exit(1)
+			return
+		}
 	},
 }
+
+// the lock file lives next to idl.yaml, which initConfig makes the working directory
+func readLock() (*config.Lock, error) {
+	lock := new(config.Lock)
+
+	f, err := os.Open(lockLocation)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open lock file")
+	}
+	defer f.Close()
+
+	err = lock.UnMarshal(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read lock file")
+	}
+
+	return lock, nil
+}
+
+func writeLock(lock *config.Lock) error {
+	f, err := os.Create(lockLocation)
+	if err != nil {
+		return errors.Wrap(err, "failed to create lock file")
+	}
+	defer f.Close()
+
+	return lock.Marshal(f)
+}
@@ -12,9 +12,11 @@ import (
 
 var (
 	packageVersion *semver.Version
+	force          bool
 )
 
 func init() {
+	pushCommand.Flags().BoolVar(&force, "force", false, "Overwrite a published version, requires an admin token in $IDL_TOKEN")
 	RootCmd.AddCommand(pushCommand)
 }
 
@@ -44,6 +46,7 @@ var pushCommand = &cobra.Command{
 		err := client.Push(client.PushOptions{
 			Configuration: configuration,
 			Version:       packageVersion,
+			Force:         force,
 		})
 		if err != nil {
 			cmd.PrintErrln(err)
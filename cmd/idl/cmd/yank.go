@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/syncromatics/idl-repository/pkg/client"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	yankedVersion *semver.Version
+	yankType      string
+	yankReason    string
+)
+
+func init() {
+	yankCommand.Flags().StringVar(&yankType, "type", "", "Only yank this type, by default every type the project provides is yanked")
+	yankCommand.Flags().StringVar(&yankReason, "reason", "", "Why the version should no longer be used")
+	unyankCommand.Flags().StringVar(&yankType, "type", "", "Only unyank this type, by default every type the project provides is unyanked")
+	RootCmd.AddCommand(yankCommand)
+	RootCmd.AddCommand(unyankCommand)
+}
+
+func yankArgs(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("requires version")
+	}
+
+	var err error
+	yankedVersion, err = semver.NewVersion(args[0])
+	if err != nil {
+		return errors.Wrap(err, "invalid version")
+	}
+
+	err = initConfig()
+	if err != nil {
+		return errors.Wrap(err, "invalid config")
+	}
+
+	return nil
+}
+
+var yankCommand = &cobra.Command{
+	Use:   "yank [version]",
+	Short: "hide a published version from version ranges",
+	Long:  "Yanked versions are no longer picked for version ranges, projects with the version in their idl.lock or asking for it exactly can still pull it.",
+	Args:  yankArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := client.Yank(client.YankOptions{
+			Configuration: configuration,
+			Version:       yankedVersion,
+			Type:          yankType,
+			Reason:        yankReason,
+		})
+		if err != nil {
+			cmd.PrintErrln(err)
+			os.Exit(1)
+			return
+		}
+	},
+}
+
+var unyankCommand = &cobra.Command{
+	Use:   "unyank [version]",
+	Short: "make a yanked version available to version ranges again",
+	Long:  "Unyank undoes yank, the version is picked for version ranges again.",
+	Args:  yankArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := client.Unyank(client.YankOptions{
+			Configuration: configuration,
+			Version:       yankedVersion,
+			Type:          yankType,
+		})
+		if err != nil {
+			cmd.PrintErrln(err)
+			os.Exit(1)
+			return
+		}
+	},
+}
@@ -0,0 +1,879 @@
+package compatibility
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// avroLogical maps avro idl logical types to the type they are written as
+var avroLogical = map[string]string{
+	"date": "int", "time_ms": "int", "timestamp_ms": "long",
+	"local_timestamp_ms": "long", "uuid": "string",
+}
+
+// avroPromotions lists what each written type can be read as besides itself
+var avroPromotions = map[string][]string{
+	"int":    {"long", "float", "double"},
+	"long":   {"float", "double"},
+	"float":  {"double"},
+	"string": {"bytes"},
+	"bytes":  {"string"},
+}
+
+type avroSchema struct {
+	kind     string
+	name     string
+	aliases  []string
+	fields   []*avroField
+	symbols  []string
+	fallback string
+	items    *avroSchema
+	branches []*avroSchema
+	size     int
+	// ref holds the names a reference to a named type may resolve to
+	ref []string
+}
+
+type avroField struct {
+	name       string
+	aliases    []string
+	schema     *avroSchema
+	hasDefault bool
+}
+
+type avroRoot struct {
+	file   string
+	key    string
+	schema *avroSchema
+}
+
+type avroTypes struct {
+	named map[string]*avroSchema
+	roots []avroRoot
+}
+
+func checkAvro(mode Mode, previous Files, current Files) []Issue {
+	old, issues := parseAvroFiles(previous.withExtensions(".avsc", ".avdl"))
+	if len(issues) > 0 {
+		old = &avroTypes{named: map[string]*avroSchema{}}
+	}
+
+	new, issues := parseAvroFiles(current.withExtensions(".avsc", ".avdl"))
+	if len(issues) > 0 {
+		return issues
+	}
+
+	// types reached through fields are checked once, shared resolvers remember them
+	backward := newAvroResolver(new, old)
+	forward := newAvroResolver(old, new)
+
+	issues = []Issue{}
+	for _, before := range old.roots {
+		after, ok := new.find(before)
+		if !ok {
+			issues = append(issues, Issue{before.file, fmt.Sprintf("type '%s' was removed", before.key)})
+			continue
+		}
+
+		if mode.backward() {
+			for _, problem := range backward.resolve(after.schema, before.schema, after.key) {
+				issues = append(issues, Issue{after.file, problem + " (backward)"})
+			}
+		}
+		if mode.forward() {
+			for _, problem := range forward.resolve(before.schema, after.schema, after.key) {
+				issues = append(issues, Issue{after.file, problem + " (forward)"})
+			}
+		}
+	}
+
+	return issues
+}
+
+func parseAvroFiles(files Files) (*avroTypes, []Issue) {
+	types := &avroTypes{named: map[string]*avroSchema{}}
+
+	issues := []Issue{}
+	for _, name := range files.names() {
+		var err error
+		if strings.HasSuffix(strings.ToLower(name), ".avsc") {
+			err = types.parseAvsc(name, files[name])
+		} else {
+			err = types.parseAvdl(name, files[name])
+		}
+		if err != nil {
+			issues = append(issues, Issue{name, fmt.Sprintf("failed to parse: %s", err)})
+		}
+	}
+
+	return types, issues
+}
+
+// find looks up the type a previous root became, following aliases for renamed types
+func (t *avroTypes) find(previous avroRoot) (avroRoot, bool) {
+	for _, root := range t.roots {
+		if root.key == previous.key {
+			return root, true
+		}
+	}
+
+	for _, root := range t.roots {
+		for _, alias := range root.schema.aliases {
+			if alias == previous.key {
+				return root, true
+			}
+		}
+	}
+
+	return avroRoot{}, false
+}
+
+func (t *avroTypes) deref(s *avroSchema) *avroSchema {
+	if s.kind != "ref" {
+		return s
+	}
+
+	for _, name := range s.ref {
+		if named, ok := t.named[name]; ok {
+			return named
+		}
+	}
+
+	// types from files outside the archive only compare by name
+	return &avroSchema{kind: "unknown", name: s.ref[len(s.ref)-1]}
+}
+
+func (t *avroTypes) register(s *avroSchema) error {
+	if _, ok := t.named[s.name]; ok {
+		return errors.New(fmt.Sprintf("type '%s' is defined more than once", s.name))
+	}
+	t.named[s.name] = s
+	return nil
+}
+
+func qualifyAvro(namespace string, name string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func avroRef(namespace string, name string) *avroSchema {
+	ref := []string{qualifyAvro(namespace, name)}
+	if ref[0] != name {
+		ref = append(ref, name)
+	}
+	return &avroSchema{kind: "ref", ref: ref}
+}
+
+func (t *avroTypes) parseAvsc(file string, source []byte) error {
+	var value interface{}
+	err := json.Unmarshal(source, &value)
+	if err != nil {
+		return err
+	}
+
+	schema, err := t.avscSchema(value, "")
+	if err != nil {
+		return err
+	}
+
+	key := schema.name
+	if key == "" {
+		key = file
+	}
+	t.roots = append(t.roots, avroRoot{file, key, schema})
+	return nil
+}
+
+func (t *avroTypes) avscSchema(value interface{}, namespace string) (*avroSchema, error) {
+	switch v := value.(type) {
+	case string:
+		if avroPrimitives[v] {
+			return &avroSchema{kind: v}, nil
+		}
+		return avroRef(namespace, v), nil
+
+	case []interface{}:
+		union := &avroSchema{kind: "union"}
+		for _, branch := range v {
+			s, err := t.avscSchema(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			union.branches = append(union.branches, s)
+		}
+		return union, nil
+
+	case map[string]interface{}:
+		return t.avscObject(v, namespace)
+	}
+
+	return nil, errors.New(fmt.Sprintf("unexpected schema %v", value))
+}
+
+func (t *avroTypes) avscObject(v map[string]interface{}, namespace string) (*avroSchema, error) {
+	kind, ok := v["type"].(string)
+	if !ok {
+		// {"type": {...}} wraps another schema
+		if inner, ok := v["type"]; ok {
+			return t.avscSchema(inner, namespace)
+		}
+		return nil, errors.New("schema is missing a type")
+	}
+
+	s := &avroSchema{kind: kind}
+	switch kind {
+	case "record", "error", "enum", "fixed":
+		if kind == "error" {
+			s.kind = "record"
+		}
+
+		name, _ := v["name"].(string)
+		if name == "" {
+			return nil, errors.New(fmt.Sprintf("%s is missing a name", kind))
+		}
+		if ns, ok := v["namespace"].(string); ok && !strings.Contains(name, ".") {
+			namespace = ns
+		}
+		s.name = qualifyAvro(namespace, name)
+		if i := strings.LastIndex(s.name, "."); i >= 0 {
+			namespace = s.name[:i]
+		}
+		s.aliases = avscAliases(v["aliases"], namespace)
+
+		err := t.register(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch kind {
+	case "record", "error":
+		fields, _ := v["fields"].([]interface{})
+		for _, f := range fields {
+			field, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("record '%s' has an invalid field", s.name))
+			}
+
+			name, _ := field["name"].(string)
+			schema, err := t.avscSchema(field["type"], namespace)
+			if err != nil {
+				return nil, errors.Wrapf(err, "field '%s' of '%s'", name, s.name)
+			}
+
+			_, hasDefault := field["default"]
+			s.fields = append(s.fields, &avroField{
+				name:       name,
+				aliases:    avscAliases(field["aliases"], ""),
+				schema:     schema,
+				hasDefault: hasDefault,
+			})
+		}
+
+	case "enum":
+		symbols, _ := v["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			text, _ := symbol.(string)
+			s.symbols = append(s.symbols, text)
+		}
+		s.fallback, _ = v["default"].(string)
+
+	case "fixed":
+		size, _ := v["size"].(float64)
+		s.size = int(size)
+
+	case "array":
+		items, err := t.avscSchema(v["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		s.items = items
+
+	case "map":
+		values, err := t.avscSchema(v["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		s.items = values
+
+	default:
+		if !avroPrimitives[kind] {
+			return avroRef(namespace, kind), nil
+		}
+	}
+
+	return s, nil
+}
+
+func avscAliases(value interface{}, namespace string) []string {
+	aliases := []string{}
+	list, _ := value.([]interface{})
+	for _, alias := range list {
+		if text, ok := alias.(string); ok {
+			aliases = append(aliases, qualifyAvro(namespace, text))
+		}
+	}
+	return aliases
+}
+
+type avdlParser struct {
+	parser
+	types     *avroTypes
+	file      string
+	namespace string
+}
+
+type avdlAnnotations map[string][]token
+
+func (a avdlAnnotations) text(name string) string {
+	for _, t := range a[name] {
+		if t.kind == stringToken {
+			return t.text
+		}
+	}
+	return ""
+}
+
+func (a avdlAnnotations) strings(name string) []string {
+	values := []string{}
+	for _, t := range a[name] {
+		if t.kind == stringToken {
+			values = append(values, t.text)
+		}
+	}
+	return values
+}
+
+func (t *avroTypes) parseAvdl(file string, source []byte) error {
+	tokens, err := lex(source)
+	if err != nil {
+		return err
+	}
+
+	p := &avdlParser{parser: parser{tokens: tokens}, types: t, file: file}
+	for p.peek().kind != eofToken {
+		annotations, err := p.annotations()
+		if err != nil {
+			return err
+		}
+
+		switch p.peek().text {
+		case "protocol":
+			p.next()
+			if ns := annotations.text("namespace"); ns != "" {
+				p.namespace = ns
+			}
+			_, err = p.ident()
+			if err == nil {
+				err = p.expect("{")
+			}
+			if err == nil {
+				err = p.protocol()
+			}
+		case "namespace":
+			p.next()
+			p.namespace, err = p.ident()
+			if err == nil {
+				err = p.expect(";")
+			}
+		case "schema", "import":
+			err = p.skipStatement()
+		default:
+			err = p.definition(annotations)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *avdlParser) protocol() error {
+	for {
+		if p.accept("}") {
+			return nil
+		}
+		if p.peek().kind == eofToken {
+			return p.unexpected(p.peek(), "'}'")
+		}
+
+		annotations, err := p.annotations()
+		if err != nil {
+			return err
+		}
+
+		switch p.peek().text {
+		case "import":
+			err = p.skipStatement()
+		case "record", "error", "enum", "fixed":
+			err = p.definition(annotations)
+		default:
+			// messages do not describe data
+			err = p.skipStatement()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *avdlParser) annotations() (avdlAnnotations, error) {
+	annotations := avdlAnnotations{}
+	for p.accept("@") {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		for p.accept("-") {
+			part, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			name += "-" + part
+		}
+
+		err = p.expect("(")
+		if err != nil {
+			return nil, err
+		}
+
+		value := []token{}
+		depth := 1
+		for {
+			t := p.next()
+			if t.kind == eofToken {
+				return nil, p.unexpected(t, "')'")
+			}
+			if t.kind == symbolToken && t.text == "(" {
+				depth++
+			}
+			if t.kind == symbolToken && t.text == ")" {
+				depth--
+				if depth == 0 {
+					break
+				}
+			}
+			value = append(value, t)
+		}
+		annotations[name] = value
+	}
+	return annotations, nil
+}
+
+func (p *avdlParser) definition(annotations avdlAnnotations) error {
+	t := p.next()
+	if t.kind != identToken || (t.text != "record" && t.text != "error" && t.text != "enum" && t.text != "fixed") {
+		return p.unexpected(t, "a record, enum or fixed")
+	}
+
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+
+	namespace := p.namespace
+	if ns := annotations.text("namespace"); ns != "" {
+		namespace = ns
+	}
+
+	s := &avroSchema{kind: t.text, name: qualifyAvro(namespace, name)}
+	if s.kind == "error" {
+		s.kind = "record"
+	}
+	for _, alias := range annotations.strings("aliases") {
+		s.aliases = append(s.aliases, qualifyAvro(namespace, alias))
+	}
+
+	err = p.types.register(s)
+	if err != nil {
+		return err
+	}
+	p.types.roots = append(p.types.roots, avroRoot{p.file, s.name, s})
+
+	switch s.kind {
+	case "record":
+		return p.record(s)
+	case "enum":
+		return p.enum(s)
+	}
+
+	err = p.expect("(")
+	if err != nil {
+		return err
+	}
+	size := p.next()
+	s.size, err = strconv.Atoi(size.text)
+	if err != nil {
+		return p.unexpected(size, "a size")
+	}
+	err = p.expect(")")
+	if err == nil {
+		err = p.expect(";")
+	}
+	return err
+}
+
+func (p *avdlParser) record(s *avroSchema) error {
+	err := p.expect("{")
+	if err != nil {
+		return err
+	}
+
+	for !p.accept("}") {
+		// annotations before the type apply to every field declared with it
+		shared, err := p.annotations()
+		if err != nil {
+			return err
+		}
+
+		kind, err := p.schema()
+		if err != nil {
+			return err
+		}
+
+		for {
+			annotations, err := p.annotations()
+			if err != nil {
+				return err
+			}
+
+			name, err := p.ident()
+			if err != nil {
+				return err
+			}
+
+			aliases := append(shared.strings("aliases"), annotations.strings("aliases")...)
+			field := &avroField{name: name, schema: kind, aliases: aliases}
+			if p.accept("=") {
+				field.hasDefault = true
+				err = p.skipValue()
+				if err != nil {
+					return err
+				}
+			}
+			s.fields = append(s.fields, field)
+
+			if p.accept(";") {
+				break
+			}
+			err = p.expect(",")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *avdlParser) enum(s *avroSchema) error {
+	err := p.expect("{")
+	if err != nil {
+		return err
+	}
+
+	for {
+		symbol, err := p.ident()
+		if err != nil {
+			return err
+		}
+		s.symbols = append(s.symbols, symbol)
+
+		if p.accept("}") {
+			break
+		}
+		err = p.expect(",")
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.accept("=") {
+		s.fallback, err = p.ident()
+		if err != nil {
+			return err
+		}
+		return p.expect(";")
+	}
+	p.accept(";")
+	return nil
+}
+
+func (p *avdlParser) schema() (*avroSchema, error) {
+	_, err := p.annotations()
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+
+	var s *avroSchema
+	switch {
+	case name == "array" || name == "map":
+		err = p.expect("<")
+		if err != nil {
+			return nil, err
+		}
+		items, err := p.schema()
+		if err != nil {
+			return nil, err
+		}
+		err = p.expect(">")
+		if err != nil {
+			return nil, err
+		}
+		s = &avroSchema{kind: name, items: items}
+
+	case name == "union":
+		err = p.expect("{")
+		if err != nil {
+			return nil, err
+		}
+		s = &avroSchema{kind: "union"}
+		for {
+			branch, err := p.schema()
+			if err != nil {
+				return nil, err
+			}
+			s.branches = append(s.branches, branch)
+
+			if p.accept("}") {
+				break
+			}
+			err = p.expect(",")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case name == "decimal":
+		for _, expected := range []string{"(", "", ",", "", ")"} {
+			t := p.next()
+			if (expected == "" && t.kind != numberToken) || (expected != "" && t.text != expected) {
+				return nil, p.unexpected(t, "decimal(precision, scale)")
+			}
+		}
+		s = &avroSchema{kind: "bytes"}
+
+	case avroPrimitives[name]:
+		s = &avroSchema{kind: name}
+
+	case avroLogical[name] != "":
+		s = &avroSchema{kind: avroLogical[name]}
+
+	default:
+		s = avroRef(p.namespace, name)
+	}
+
+	if p.accept("?") {
+		s = &avroSchema{kind: "union", branches: []*avroSchema{{kind: "null"}, s}}
+	}
+	return s, nil
+}
+
+// skipValue skips a default value or parameter list up to the next ',' or ';' outside of brackets
+func (p *avdlParser) skipValue() error {
+	depth := 0
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == eofToken:
+			return p.unexpected(t, "';'")
+		case t.kind != symbolToken:
+		case t.text == "{" || t.text == "[" || t.text == "(":
+			depth++
+		case t.text == "}" || t.text == "]" || t.text == ")":
+			depth--
+		case (t.text == "," || t.text == ";") && depth <= 0:
+			return nil
+		}
+		p.next()
+	}
+}
+
+type avroPair struct {
+	reader *avroSchema
+	writer *avroSchema
+}
+
+type avroResolver struct {
+	readers  *avroTypes
+	writers  *avroTypes
+	seen     map[avroPair]bool
+	problems []string
+}
+
+func newAvroResolver(readers *avroTypes, writers *avroTypes) *avroResolver {
+	return &avroResolver{readers: readers, writers: writers, seen: map[avroPair]bool{}}
+}
+
+// resolve applies avro's schema resolution rules and lists why data written
+// with the writer schema cannot be read with the reader schema
+func (r *avroResolver) resolve(reader *avroSchema, writer *avroSchema, path string) []string {
+	r.problems = []string{}
+	r.check(reader, writer, path)
+	return r.problems
+}
+
+func (r *avroResolver) problem(format string, args ...interface{}) {
+	r.problems = append(r.problems, fmt.Sprintf(format, args...))
+}
+
+func (r *avroResolver) check(reader *avroSchema, writer *avroSchema, path string) {
+	reader = r.readers.deref(reader)
+	writer = r.writers.deref(writer)
+
+	pair := avroPair{reader, writer}
+	if r.seen[pair] {
+		return
+	}
+	r.seen[pair] = true
+
+	if writer.kind == "union" {
+		for _, branch := range writer.branches {
+			r.check(reader, branch, path)
+		}
+		return
+	}
+
+	if reader.kind == "union" {
+		branch, ok := r.branchFor(reader, writer)
+		if !ok {
+			r.problem("'%s' written as '%s' is not in the reader union '%s'", path, r.describe(r.writers, writer), r.describe(r.readers, reader))
+			return
+		}
+		r.check(branch, writer, path)
+		return
+	}
+
+	if !avroReadable(reader, writer) {
+		r.problem("'%s' written as '%s' cannot be read as '%s'", path, r.describe(r.writers, writer), r.describe(r.readers, reader))
+		return
+	}
+
+	switch reader.kind {
+	case "record":
+		r.checkRecord(reader, writer, path)
+	case "enum":
+		for _, symbol := range writer.symbols {
+			if !containsString(reader.symbols, symbol) && reader.fallback == "" {
+				r.problem("'%s' symbol '%s' is not in the reader enum, which has no default", path, symbol)
+			}
+		}
+	case "fixed":
+		if reader.size != writer.size {
+			r.problem("'%s' written with size %d cannot be read with size %d", path, writer.size, reader.size)
+		}
+	case "array":
+		r.check(reader.items, writer.items, path+"[]")
+	case "map":
+		r.check(reader.items, writer.items, path+"{}")
+	}
+}
+
+func (r *avroResolver) checkRecord(reader *avroSchema, writer *avroSchema, path string) {
+	for _, field := range reader.fields {
+		written, ok := writerField(writer, field)
+		if !ok {
+			if !field.hasDefault {
+				r.problem("'%s.%s' has no default and is missing from the writer", path, field.name)
+			}
+			continue
+		}
+
+		r.check(field.schema, written.schema, path+"."+field.name)
+	}
+}
+
+func writerField(writer *avroSchema, field *avroField) (*avroField, bool) {
+	for _, f := range writer.fields {
+		if f.name == field.name {
+			return f, true
+		}
+	}
+	for _, f := range writer.fields {
+		if containsString(field.aliases, f.name) {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// branchFor picks the first reader union branch that data of the writer type resolves to
+func (r *avroResolver) branchFor(union *avroSchema, writer *avroSchema) (*avroSchema, bool) {
+	for _, exact := range []bool{true, false} {
+		for _, branch := range union.branches {
+			branch = r.readers.deref(branch)
+			if branch.kind != writer.kind && exact {
+				continue
+			}
+			if avroReadable(branch, writer) {
+				return branch, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// avroReadable checks the kinds and names match, not what the types contain
+func avroReadable(reader *avroSchema, writer *avroSchema) bool {
+	if reader.kind != writer.kind {
+		return containsString(avroPromotions[writer.kind], reader.kind)
+	}
+
+	switch reader.kind {
+	case "record", "enum", "fixed", "unknown":
+		return avroNamesMatch(reader, writer)
+	}
+	return true
+}
+
+func avroNamesMatch(reader *avroSchema, writer *avroSchema) bool {
+	if shortName(reader.name) == shortName(writer.name) {
+		return true
+	}
+	for _, alias := range reader.aliases {
+		if alias == writer.name || shortName(alias) == shortName(writer.name) {
+			return true
+		}
+	}
+	return false
+}
+
+func shortName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func (r *avroResolver) describe(types *avroTypes, s *avroSchema) string {
+	s = types.deref(s)
+	switch s.kind {
+	case "record", "enum", "fixed", "unknown":
+		return s.name
+	case "array", "map":
+		return fmt.Sprintf("%s<%s>", s.kind, r.describe(types, s.items))
+	case "union":
+		branches := []string{}
+		for _, branch := range s.branches {
+			branches = append(branches, r.describe(types, branch))
+		}
+		return fmt.Sprintf("union{%s}", strings.Join(branches, ", "))
+	}
+	return s.kind
+}
@@ -0,0 +1,120 @@
+package compatibility_test
+
+import (
+	"github.com/syncromatics/idl-repository/internal/compatibility"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const previousAvdl = `
+@namespace("com.example.v1")
+protocol Test {
+    import idl "other.avdl";
+
+    enum Status {
+        ACTIVE, RETIRED
+    }
+
+    fixed Hash(16);
+
+    /** a vehicle */
+    record Thing {
+        string name;
+        long timestampMs;
+        int count = 0;
+        @aliases(["labels"]) array<string> tags = [];
+        union { null, Status } status = null;
+        Hash hash;
+        decimal(9, 2) price;
+    }
+
+    Thing get(string name) throws Failure;
+    void ping() oneway;
+}
+`
+
+const previousAvsc = `{
+  "type": "record",
+  "name": "Position",
+  "namespace": "com.example.v1",
+  "fields": [
+    {"name": "latitude", "type": "float"},
+    {"name": "source", "type": {"type": "enum", "name": "Source", "symbols": ["GPS", "MANUAL"], "default": "GPS"}},
+    {"name": "history", "type": {"type": "array", "items": "Position"}, "default": []}
+  ]
+}`
+
+func avroFiles(avdl string, avsc string) compatibility.Files {
+	return compatibility.Files{
+		"example/v1/test.avdl":     []byte(avdl),
+		"example/v1/position.avsc": []byte(avsc),
+	}
+}
+
+var _ = Describe("avro compatibility", func() {
+	DescribeTable("checking idl changes",
+		func(mode compatibility.Mode, find string, replace string, expected ...string) {
+			current := replaceOnce(previousAvdl, find, replace)
+
+			issues := compatibility.Check(mode, "avro", avroFiles(previousAvdl, previousAvsc), avroFiles(current, previousAvsc))
+
+			messages := []string{}
+			for _, issue := range issues {
+				Expect(issue.File).To(Equal("example/v1/test.avdl"))
+				messages = append(messages, issue.Message)
+			}
+			Expect(messages).To(ConsistOf(expected))
+		},
+		Entry("unchanged", compatibility.Full, "", ""),
+		Entry("added field with a default", compatibility.Full, "decimal(9, 2) price;", "decimal(9, 2) price;\n string? driver = null;"),
+		Entry("promoted type", compatibility.Backward, "int count = 0;", "long count = 0;"),
+		Entry("renamed field with alias", compatibility.Full, "string name;", `@aliases(["name"]) string title;`,
+			"'com.example.v1.Thing.name' has no default and is missing from the writer (forward)"),
+		Entry("added field without a default", compatibility.Backward, "decimal(9, 2) price;", "decimal(9, 2) price;\n string driver;",
+			"'com.example.v1.Thing.driver' has no default and is missing from the writer (backward)"),
+		Entry("removed field without a default", compatibility.Forward, "        string name;\n", "",
+			"'com.example.v1.Thing.name' has no default and is missing from the writer (forward)"),
+		Entry("removed field with a default", compatibility.Full, "        int count = 0;\n", ""),
+		Entry("narrowed type", compatibility.Full, "int count = 0;", "long count = 0;",
+			"'com.example.v1.Thing.count' written as 'long' cannot be read as 'int' (forward)"),
+		Entry("changed type", compatibility.Backward, "string name;", "int name;",
+			"'com.example.v1.Thing.name' written as 'string' cannot be read as 'int' (backward)"),
+		Entry("removed enum symbol", compatibility.Backward, "ACTIVE, RETIRED", "ACTIVE",
+			"'com.example.v1.Status' symbol 'RETIRED' is not in the reader enum, which has no default (backward)"),
+		Entry("changed fixed size", compatibility.Backward, "fixed Hash(16);", "fixed Hash(32);",
+			"'com.example.v1.Hash' written with size 16 cannot be read with size 32 (backward)"),
+		Entry("removed union branch", compatibility.Backward, "union { null, Status } status = null;", "union { null } status = null;",
+			"'com.example.v1.Thing.status' written as 'com.example.v1.Status' is not in the reader union 'union{null}' (backward)"),
+		Entry("removed type", compatibility.Backward, "    fixed Hash(16);\n", "",
+			"type 'com.example.v1.Hash' was removed",
+			"'com.example.v1.Thing.hash' written as 'com.example.v1.Hash' cannot be read as 'Hash' (backward)"),
+	)
+
+	It("should follow json schemas", func() {
+		current := `{
+  "type": "record",
+  "name": "Position",
+  "namespace": "com.example.v1",
+  "fields": [
+    {"name": "latitude", "type": "double"},
+    {"name": "source", "type": {"type": "enum", "name": "Source", "symbols": ["GPS"], "default": "GPS"}},
+    {"name": "history", "type": {"type": "array", "items": "Position"}, "default": []}
+  ]
+}`
+
+		issues := compatibility.Check(compatibility.Full, "avro", avroFiles(previousAvdl, previousAvsc), avroFiles(previousAvdl, current))
+
+		Expect(issues).To(Equal([]compatibility.Issue{
+			{File: "example/v1/position.avsc", Message: "'com.example.v1.Position.latitude' written as 'double' cannot be read as 'float' (forward)"},
+		}))
+	})
+
+	It("should report schemas that do not parse", func() {
+		issues := compatibility.Check(compatibility.Backward, "avro", avroFiles(previousAvdl, previousAvsc), avroFiles("protocol Test { record A { string } }", previousAvsc))
+
+		Expect(issues).To(HaveLen(1))
+		Expect(issues[0].Message).To(Equal("failed to parse: line 1: expected a name but found '}'"))
+	})
+})
@@ -0,0 +1,151 @@
+package compatibility
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Mode is how strictly a new version must stay compatible with the previous version.
+// Backward means consumers on the new version can still read data produced with the
+// previous one, forward means consumers on the previous version can read new data.
+type Mode string
+
+const (
+	None     Mode = "none"
+	Backward Mode = "backward"
+	Forward  Mode = "forward"
+	Full     Mode = "full"
+)
+
+// Files maps file names inside an archive to their contents
+type Files map[string][]byte
+
+type Issue struct {
+	File    string `json:"file"`
+	Message string `json:"message"`
+}
+
+type checker struct {
+	check func(mode Mode, previous Files, current Files) []Issue
+	// types limits the checker to archives of these types, every type when empty
+	types []string
+}
+
+var checkers = []checker{
+	{check: checkProto},
+	{check: checkAvro},
+	// yaml and json in other types, such as generated swagger, are not the api being published
+	{check: checkOpenAPI, types: []string{"openapi", "swagger"}},
+}
+
+func ParseMode(text string) (Mode, error) {
+	mode := Mode(strings.ToLower(strings.TrimSpace(text)))
+	switch mode {
+	case None, Backward, Forward, Full:
+		return mode, nil
+	case "":
+		return None, nil
+	}
+	return None, errors.New(fmt.Sprintf("unknown compatibility mode '%s', expected none, backward, forward or full", text))
+}
+
+func (m Mode) backward() bool {
+	return m == Backward || m == Full
+}
+
+func (m Mode) forward() bool {
+	return m == Forward || m == Full
+}
+
+// Check compares every schema found in the previous and current versions of a type
+// and returns what breaks under the mode
+func Check(mode Mode, idlType string, previous Files, current Files) []Issue {
+	if mode == None {
+		return nil
+	}
+
+	issues := []Issue{}
+	for _, c := range checkers {
+		if c.appliesTo(idlType) {
+			issues = append(issues, c.check(mode, previous, current)...)
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].File < issues[j].File
+	})
+	return issues
+}
+
+func (c checker) appliesTo(idlType string) bool {
+	if len(c.types) == 0 {
+		return true
+	}
+	for _, t := range c.types {
+		if strings.EqualFold(t, idlType) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadArchive reads the regular files of a gzipped tar
+func ReadArchive(reader io.Reader) (Files, error) {
+	gzr, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read gzip")
+	}
+	defer gzr.Close()
+
+	files := Files{}
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read tar")
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read '%s'", header.Name)
+		}
+
+		files[strings.TrimPrefix(path.Clean("/"+header.Name), "/")] = b
+	}
+}
+
+func (f Files) withExtensions(extensions ...string) Files {
+	matching := Files{}
+	for name, contents := range f {
+		for _, extension := range extensions {
+			if strings.HasSuffix(strings.ToLower(name), extension) {
+				matching[name] = contents
+			}
+		}
+	}
+	return matching
+}
+
+func (f Files) names() []string {
+	names := []string{}
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,13 @@
+package compatibility_test
+
+import "strings"
+
+func replaceOnce(source string, find string, replace string) string {
+	if find == "" {
+		return source
+	}
+	if !strings.Contains(source, find) {
+		panic("test source does not contain " + find)
+	}
+	return strings.Replace(source, find, replace, 1)
+}
@@ -0,0 +1,214 @@
+package compatibility
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+type tokenKind int
+
+const (
+	identToken tokenKind = iota
+	numberToken
+	stringToken
+	symbolToken
+	eofToken
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+// lex splits C-like schema languages such as protobuf and avro idl into tokens, dropping comments
+func lex(source []byte) ([]token, error) {
+	tokens := []token{}
+	line := 1
+
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		case c == '/' && i+1 < len(source) && source[i+1] == '/':
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+
+		case c == '/' && i+1 < len(source) && source[i+1] == '*':
+			i += 2
+			for i < len(source) && !(source[i] == '*' && i+1 < len(source) && source[i+1] == '/') {
+				if source[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			if i >= len(source) {
+				return nil, errors.New(fmt.Sprintf("line %d: unterminated comment", line))
+			}
+			i += 2
+
+		case c == '"' || c == '\'':
+			start := line
+			j := i + 1
+			for j < len(source) && source[j] != c {
+				if source[j] == '\\' {
+					j++
+				}
+				if j < len(source) && source[j] == '\n' {
+					line++
+				}
+				j++
+			}
+			if j >= len(source) {
+				return nil, errors.New(fmt.Sprintf("line %d: unterminated string", start))
+			}
+			tokens = append(tokens, token{stringToken, string(source[i+1 : j]), start})
+			i = j + 1
+
+		case c == '`':
+			j := i + 1
+			for j < len(source) && source[j] != '`' {
+				j++
+			}
+			if j >= len(source) {
+				return nil, errors.New(fmt.Sprintf("line %d: unterminated identifier", line))
+			}
+			tokens = append(tokens, token{identToken, string(source[i+1 : j]), line})
+			i = j + 1
+
+		case isDigit(c) || (c == '-' && i+1 < len(source) && isDigit(source[i+1])):
+			j := i + 1
+			for j < len(source) && (isIdentChar(source[j]) ||
+				((source[j] == '+' || source[j] == '-') && (source[j-1] == 'e' || source[j-1] == 'E'))) {
+				j++
+			}
+			tokens = append(tokens, token{numberToken, string(source[i:j]), line})
+			i = j
+
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(source) && isIdentChar(source[j]) {
+				j++
+			}
+			tokens = append(tokens, token{identToken, string(source[i:j]), line})
+			i = j
+
+		default:
+			tokens = append(tokens, token{symbolToken, string(c), line})
+			i++
+		}
+	}
+
+	return append(tokens, token{eofToken, "", line}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != eofToken {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); t.kind != stringToken && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	t := p.next()
+	if t.kind == stringToken || t.text != text {
+		return p.unexpected(t, fmt.Sprintf("'%s'", text))
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.next()
+	if t.kind != identToken {
+		return "", p.unexpected(t, "a name")
+	}
+	return t.text, nil
+}
+
+func (p *parser) unexpected(t token, expected string) error {
+	found := fmt.Sprintf("'%s'", t.text)
+	if t.kind == eofToken {
+		found = "end of file"
+	}
+	return errors.New(fmt.Sprintf("line %d: expected %s but found %s", t.line, expected, found))
+}
+
+// skipStatement skips everything up to and including the next ';' outside of brackets
+func (p *parser) skipStatement() error {
+	depth := 0
+	for {
+		t := p.next()
+		switch {
+		case t.kind == eofToken:
+			return p.unexpected(t, "';'")
+		case t.kind != symbolToken:
+		case t.text == "{" || t.text == "[" || t.text == "(":
+			depth++
+		case t.text == "}" || t.text == "]" || t.text == ")":
+			depth--
+		case t.text == ";" && depth <= 0:
+			return nil
+		}
+	}
+}
+
+// skipBlock skips a '{' ... '}' block including nested blocks
+func (p *parser) skipBlock() error {
+	err := p.expect("{")
+	if err != nil {
+		return err
+	}
+
+	depth := 1
+	for depth > 0 {
+		t := p.next()
+		switch {
+		case t.kind == eofToken:
+			return p.unexpected(t, "'}'")
+		case t.kind != symbolToken:
+		case t.text == "{":
+			depth++
+		case t.text == "}":
+			depth--
+		}
+	}
+	return nil
+}
@@ -0,0 +1,664 @@
+package compatibility
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+var pathParameter = regexp.MustCompile(`\{[^}]*\}`)
+
+// maxSchemaDepth stops comparing recursive schemas
+const maxSchemaDepth = 16
+
+type openAPIDocument struct {
+	root map[string]interface{}
+}
+
+type openAPIOperation struct {
+	parameters  map[string]map[string]interface{}
+	requestBody map[string]interface{}
+	responses   map[string]interface{}
+}
+
+type openAPIDiff struct {
+	old    *openAPIDocument
+	new    *openAPIDocument
+	issues []string
+}
+
+func checkOpenAPI(mode Mode, previous Files, current Files) []Issue {
+	old := parseOpenAPIFiles(previous)
+	new := parseOpenAPIFiles(current)
+
+	issues := []Issue{}
+	for _, name := range sortedDocuments(old) {
+		after, ok := new[name]
+		if !ok {
+			// clients of the new version never call an api that is gone
+			if mode.backward() {
+				issues = append(issues, Issue{name, "api was removed"})
+			}
+			continue
+		}
+
+		// backward keeps clients written against the previous version working
+		if mode.backward() {
+			for _, message := range diffOpenAPI(old[name], after) {
+				issues = append(issues, Issue{name, message + " (backward)"})
+			}
+		}
+		// forward keeps clients written against the new version working with servers still on the previous one
+		if mode.forward() {
+			for _, message := range diffOpenAPIForward(old[name], after) {
+				issues = append(issues, Issue{name, message + " (forward)"})
+			}
+		}
+	}
+
+	return issues
+}
+
+func parseOpenAPIFiles(files Files) map[string]*openAPIDocument {
+	documents := map[string]*openAPIDocument{}
+	for name, contents := range files.withExtensions(".yaml", ".yml", ".json") {
+		var value interface{}
+		err := yaml.Unmarshal(contents, &value)
+		if err != nil {
+			// not every yaml file in a project is an api
+			continue
+		}
+
+		root, ok := normalizeYaml(value).(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		_, openapi := root["openapi"]
+		_, swagger := root["swagger"]
+		if openapi || swagger {
+			documents[name] = &openAPIDocument{root}
+		}
+	}
+	return documents
+}
+
+func sortedDocuments(documents map[string]*openAPIDocument) []string {
+	names := []string{}
+	for name := range documents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// normalizeYaml turns the map[interface{}]interface{} yaml produces into json style maps
+func normalizeYaml(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			m[fmt.Sprintf("%v", key)] = normalizeYaml(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeYaml(item)
+		}
+	}
+	return value
+}
+
+// diffOpenAPI lists what clients of the old document would notice when talking to the new one
+func diffOpenAPI(old *openAPIDocument, new *openAPIDocument) []string {
+	d := &openAPIDiff{old: old, new: new, issues: []string{}}
+
+	oldPaths := old.paths()
+	newPaths := new.paths()
+	for _, key := range sortedKeysOf(oldPaths) {
+		before := oldPaths[key]
+		after, ok := newPaths[key]
+		if !ok {
+			d.issue("path '%s' was removed", before["path"])
+			continue
+		}
+
+		for _, method := range openAPIMethods {
+			oldOperation, ok := old.operation(before, method)
+			if !ok {
+				continue
+			}
+
+			name := fmt.Sprintf("%s %s", strings.ToUpper(method), before["path"])
+			newOperation, ok := new.operation(after, method)
+			if !ok {
+				d.issue("operation '%s' was removed", name)
+				continue
+			}
+
+			d.operation(name, oldOperation, newOperation)
+		}
+	}
+
+	return d.issues
+}
+
+// diffOpenAPIForward lists what clients of the new document would notice when talking to
+// a server still on the old one. Added paths and operations are new features the old
+// server does not offer yet, so only what both documents describe is compared.
+func diffOpenAPIForward(old *openAPIDocument, new *openAPIDocument) []string {
+	d := &openAPIDiff{old: old, new: new, issues: []string{}}
+
+	oldPaths := old.paths()
+	newPaths := new.paths()
+	for _, key := range sortedKeysOf(newPaths) {
+		after := newPaths[key]
+		before, ok := oldPaths[key]
+		if !ok {
+			continue
+		}
+
+		for _, method := range openAPIMethods {
+			newOperation, ok := new.operation(after, method)
+			if !ok {
+				continue
+			}
+
+			oldOperation, ok := old.operation(before, method)
+			if !ok {
+				continue
+			}
+
+			d.forwardOperation(fmt.Sprintf("%s %s", strings.ToUpper(method), after["path"]), oldOperation, newOperation)
+		}
+	}
+
+	return d.issues
+}
+
+func (d *openAPIDiff) issue(format string, args ...interface{}) {
+	d.issues = append(d.issues, fmt.Sprintf(format, args...))
+}
+
+func (d *openAPIDiff) operation(name string, old *openAPIOperation, new *openAPIOperation) {
+	for _, key := range sortedKeysOf(new.parameters) {
+		after := new.parameters[key]
+		before, existed := old.parameters[key]
+
+		if isTrue(after["required"]) && (!existed || !isTrue(before["required"])) {
+			d.issue("%s: parameter '%s' in %s is newly required", name, after["name"], after["in"])
+		}
+		if !existed {
+			continue
+		}
+
+		oldType := d.parameterType(d.old, before)
+		newType := d.parameterType(d.new, after)
+		if oldType != "" && newType != "" && oldType != newType {
+			d.issue("%s: parameter '%s' in %s changed type from '%s' to '%s'", name, after["name"], after["in"], oldType, newType)
+		}
+	}
+
+	if new.requestBody != nil {
+		if isTrue(new.requestBody["required"]) && (old.requestBody == nil || !isTrue(old.requestBody["required"])) {
+			d.issue("%s: request body is newly required", name)
+		}
+
+		if old.requestBody != nil {
+			d.requestSchema(name+": request body", "body", d.bodySchema(d.old, old.requestBody), d.bodySchema(d.new, new.requestBody), 0)
+		}
+	}
+
+	for _, code := range sortedKeysOf(old.responses) {
+		before := d.old.resolve(old.responses[code])
+		after, ok := new.responses[code]
+		if !ok {
+			d.issue("%s: response %s was removed", name, code)
+			continue
+		}
+
+		d.response(fmt.Sprintf("%s: response %s", name, code), before, d.new.resolve(after))
+	}
+}
+
+func (d *openAPIDiff) response(name string, old map[string]interface{}, new map[string]interface{}) {
+	// openapi 2 has a single schema, openapi 3 a schema per media type
+	if schema, ok := old["schema"]; ok {
+		d.responseSchema(name, "body", d.old.resolve(schema), d.new.resolve(new["schema"]), 0)
+		return
+	}
+
+	oldContent := asMap(old["content"])
+	newContent := asMap(new["content"])
+	for _, mediaType := range sortedKeysOf(oldContent) {
+		after, ok := newContent[mediaType]
+		if !ok {
+			d.issue("%s '%s' was removed", name, mediaType)
+			continue
+		}
+
+		d.responseSchema(name+" '"+mediaType+"'", "body",
+			d.old.resolve(asMap(oldContent[mediaType])["schema"]),
+			d.new.resolve(asMap(after)["schema"]), 0)
+	}
+}
+
+// responseSchema flags responses that promise less than before
+func (d *openAPIDiff) responseSchema(name string, field string, old map[string]interface{}, new map[string]interface{}, depth int) {
+	if old == nil || depth > maxSchemaDepth {
+		return
+	}
+	if new == nil {
+		d.issue("%s field '%s' no longer has a schema", name, field)
+		return
+	}
+
+	old = d.old.merge(old)
+	new = d.new.merge(new)
+
+	if !d.sameType(name, field, old, new) {
+		return
+	}
+
+	oldProperties := asMap(old["properties"])
+	newProperties := asMap(new["properties"])
+	newRequired := stringList(new["required"])
+	for _, property := range stringList(old["required"]) {
+		if _, ok := newProperties[property]; ok && !containsString(newRequired, property) {
+			d.issue("%s field '%s.%s' is no longer required", name, field, property)
+		}
+	}
+
+	for _, property := range sortedKeysOf(oldProperties) {
+		after, ok := newProperties[property]
+		if !ok {
+			d.issue("%s field '%s.%s' was removed", name, field, property)
+			continue
+		}
+
+		d.responseSchema(name, field+"."+property, d.old.resolve(oldProperties[property]), d.new.resolve(after), depth+1)
+	}
+
+	if items, ok := old["items"]; ok {
+		d.responseSchema(name, field+"[]", d.old.resolve(items), d.new.resolve(new["items"]), depth+1)
+	}
+}
+
+// requestSchema flags requests that old clients would no longer be able to send
+func (d *openAPIDiff) requestSchema(name string, field string, old map[string]interface{}, new map[string]interface{}, depth int) {
+	if old == nil || new == nil || depth > maxSchemaDepth {
+		return
+	}
+
+	old = d.old.merge(old)
+	new = d.new.merge(new)
+
+	if !d.sameType(name, field, old, new) {
+		return
+	}
+
+	oldProperties := asMap(old["properties"])
+	oldRequired := stringList(old["required"])
+	for _, property := range stringList(new["required"]) {
+		if !containsString(oldRequired, property) {
+			d.issue("%s field '%s.%s' is newly required", name, field, property)
+		}
+	}
+
+	for _, value := range asList(old["enum"]) {
+		if enum, ok := new["enum"]; ok && !containsValue(asList(enum), value) {
+			d.issue("%s field '%s' no longer accepts '%v'", name, field, value)
+		}
+	}
+
+	newProperties := asMap(new["properties"])
+	for _, property := range sortedKeysOf(oldProperties) {
+		if after, ok := newProperties[property]; ok {
+			d.requestSchema(name, field+"."+property, d.old.resolve(oldProperties[property]), d.new.resolve(after), depth+1)
+		}
+	}
+
+	if items, ok := old["items"]; ok {
+		d.requestSchema(name, field+"[]", d.old.resolve(items), d.new.resolve(new["items"]), depth+1)
+	}
+}
+
+// forwardOperation flags requests the old server would refuse and answers from it that
+// clients of the new document would not expect
+func (d *openAPIDiff) forwardOperation(name string, old *openAPIOperation, new *openAPIOperation) {
+	for _, key := range sortedKeysOf(old.parameters) {
+		before := old.parameters[key]
+		after, exists := new.parameters[key]
+
+		if isTrue(before["required"]) && !exists {
+			d.issue("%s: parameter '%s' in %s that the previous version requires was removed", name, before["name"], before["in"])
+		} else if isTrue(before["required"]) && !isTrue(after["required"]) {
+			d.issue("%s: parameter '%s' in %s is no longer required", name, before["name"], before["in"])
+		}
+		if !exists {
+			continue
+		}
+
+		oldType := d.parameterType(d.old, before)
+		newType := d.parameterType(d.new, after)
+		if oldType != "" && newType != "" && oldType != newType {
+			d.issue("%s: parameter '%s' in %s changed type from '%s' to '%s'", name, after["name"], after["in"], oldType, newType)
+		}
+	}
+
+	if old.requestBody != nil {
+		if isTrue(old.requestBody["required"]) && (new.requestBody == nil || !isTrue(new.requestBody["required"])) {
+			d.issue("%s: request body is no longer required", name)
+		}
+
+		if new.requestBody != nil {
+			d.forwardRequestSchema(name+": request body", "body", d.bodySchema(d.old, old.requestBody), d.bodySchema(d.new, new.requestBody), 0)
+		}
+	}
+
+	// responses only the new document lists are never sent by the old server
+	for _, code := range sortedKeysOf(new.responses) {
+		before, ok := old.responses[code]
+		if !ok {
+			continue
+		}
+
+		d.forwardResponse(fmt.Sprintf("%s: response %s", name, code), d.old.resolve(before), d.new.resolve(new.responses[code]))
+	}
+}
+
+func (d *openAPIDiff) forwardResponse(name string, old map[string]interface{}, new map[string]interface{}) {
+	if schema, ok := new["schema"]; ok {
+		d.forwardResponseSchema(name, "body", d.old.resolve(old["schema"]), d.new.resolve(schema), 0)
+		return
+	}
+
+	oldContent := asMap(old["content"])
+	newContent := asMap(new["content"])
+	for _, mediaType := range sortedKeysOf(newContent) {
+		before, ok := oldContent[mediaType]
+		if !ok {
+			continue
+		}
+
+		d.forwardResponseSchema(name+" '"+mediaType+"'", "body",
+			d.old.resolve(asMap(before)["schema"]),
+			d.new.resolve(asMap(newContent[mediaType])["schema"]), 0)
+	}
+}
+
+// forwardRequestSchema flags requests from new clients that the old server would refuse
+func (d *openAPIDiff) forwardRequestSchema(name string, field string, old map[string]interface{}, new map[string]interface{}, depth int) {
+	if old == nil || new == nil || depth > maxSchemaDepth {
+		return
+	}
+
+	old = d.old.merge(old)
+	new = d.new.merge(new)
+
+	if !d.sameType(name, field, old, new) {
+		return
+	}
+
+	newProperties := asMap(new["properties"])
+	newRequired := stringList(new["required"])
+	for _, property := range stringList(old["required"]) {
+		if containsString(newRequired, property) {
+			continue
+		}
+		if _, ok := newProperties[property]; ok {
+			d.issue("%s field '%s.%s' is no longer required", name, field, property)
+		} else {
+			d.issue("%s field '%s.%s' that the previous version requires was removed", name, field, property)
+		}
+	}
+
+	if enum, ok := old["enum"]; ok {
+		for _, value := range asList(new["enum"]) {
+			if !containsValue(asList(enum), value) {
+				d.issue("%s field '%s' now accepts '%v'", name, field, value)
+			}
+		}
+	}
+
+	oldProperties := asMap(old["properties"])
+	for _, property := range sortedKeysOf(newProperties) {
+		if before, ok := oldProperties[property]; ok {
+			d.forwardRequestSchema(name, field+"."+property, d.old.resolve(before), d.new.resolve(newProperties[property]), depth+1)
+		}
+	}
+
+	if items, ok := new["items"]; ok {
+		d.forwardRequestSchema(name, field+"[]", d.old.resolve(old["items"]), d.new.resolve(items), depth+1)
+	}
+}
+
+// forwardResponseSchema flags fields new clients count on that the old server may leave out
+func (d *openAPIDiff) forwardResponseSchema(name string, field string, old map[string]interface{}, new map[string]interface{}, depth int) {
+	if old == nil || new == nil || depth > maxSchemaDepth {
+		return
+	}
+
+	old = d.old.merge(old)
+	new = d.new.merge(new)
+
+	if !d.sameType(name, field, old, new) {
+		return
+	}
+
+	oldProperties := asMap(old["properties"])
+	oldRequired := stringList(old["required"])
+	for _, property := range stringList(new["required"]) {
+		if containsString(oldRequired, property) {
+			continue
+		}
+		if _, ok := oldProperties[property]; ok {
+			d.issue("%s field '%s.%s' is newly required", name, field, property)
+		} else {
+			d.issue("%s field '%s.%s' was added as required", name, field, property)
+		}
+	}
+
+	newProperties := asMap(new["properties"])
+	for _, property := range sortedKeysOf(newProperties) {
+		if before, ok := oldProperties[property]; ok {
+			d.forwardResponseSchema(name, field+"."+property, d.old.resolve(before), d.new.resolve(newProperties[property]), depth+1)
+		}
+	}
+
+	if items, ok := new["items"]; ok {
+		d.forwardResponseSchema(name, field+"[]", d.old.resolve(old["items"]), d.new.resolve(items), depth+1)
+	}
+}
+
+func (d *openAPIDiff) sameType(name string, field string, old map[string]interface{}, new map[string]interface{}) bool {
+	oldType, _ := old["type"].(string)
+	newType, _ := new["type"].(string)
+	if oldType != "" && newType != "" && oldType != newType {
+		d.issue("%s field '%s' changed type from '%s' to '%s'", name, field, oldType, newType)
+		return false
+	}
+	return true
+}
+
+func (d *openAPIDiff) parameterType(doc *openAPIDocument, parameter map[string]interface{}) string {
+	if t, ok := parameter["type"].(string); ok {
+		return t
+	}
+	schema := doc.resolve(parameter["schema"])
+	t, _ := schema["type"].(string)
+	return t
+}
+
+func (d *openAPIDiff) bodySchema(doc *openAPIDocument, body map[string]interface{}) map[string]interface{} {
+	if schema, ok := body["schema"]; ok {
+		return doc.resolve(schema)
+	}
+
+	// compare the first media type both versions are likely to share
+	content := asMap(body["content"])
+	for _, mediaType := range sortedKeysOf(content) {
+		return doc.resolve(asMap(content[mediaType])["schema"])
+	}
+	return nil
+}
+
+// paths keys path items by their template so renamed path parameters still match
+func (doc *openAPIDocument) paths() map[string]map[string]interface{} {
+	paths := map[string]map[string]interface{}{}
+	for path, item := range asMap(doc.root["paths"]) {
+		resolved := doc.resolve(item)
+		if resolved == nil {
+			continue
+		}
+
+		withPath := map[string]interface{}{"path": path}
+		for k, v := range resolved {
+			withPath[k] = v
+		}
+		paths[pathParameter.ReplaceAllString(path, "{}")] = withPath
+	}
+	return paths
+}
+
+func (doc *openAPIDocument) operation(item map[string]interface{}, method string) (*openAPIOperation, bool) {
+	raw, ok := item[method]
+	if !ok {
+		return nil, false
+	}
+	op := asMap(raw)
+
+	operation := &openAPIOperation{
+		parameters: map[string]map[string]interface{}{},
+		responses:  asMap(op["responses"]),
+	}
+
+	// operation parameters override the ones shared by the path
+	for _, list := range []interface{}{item["parameters"], op["parameters"]} {
+		for _, p := range asList(list) {
+			parameter := doc.resolve(p)
+			if parameter == nil {
+				continue
+			}
+
+			if parameter["in"] == "body" {
+				operation.requestBody = parameter
+				continue
+			}
+			operation.parameters[fmt.Sprintf("%v:%v", parameter["in"], parameter["name"])] = parameter
+		}
+	}
+
+	if body, ok := op["requestBody"]; ok {
+		operation.requestBody = doc.resolve(body)
+	}
+
+	return operation, true
+}
+
+// resolve follows local references such as "#/components/schemas/Pet"
+func (doc *openAPIDocument) resolve(value interface{}) map[string]interface{} {
+	for i := 0; i < maxSchemaDepth; i++ {
+		m := asMap(value)
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return m
+		}
+
+		if !strings.HasPrefix(ref, "#/") {
+			// references to other files are compared as they are
+			return m
+		}
+
+		var target interface{} = doc.root
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			part = strings.Replace(strings.Replace(part, "~1", "/", -1), "~0", "~", -1)
+			target = asMap(target)[part]
+		}
+		value = target
+	}
+	return nil
+}
+
+// merge folds allOf into a single schema
+func (doc *openAPIDocument) merge(schema map[string]interface{}) map[string]interface{} {
+	all, ok := schema["allOf"]
+	if !ok {
+		return schema
+	}
+
+	merged := map[string]interface{}{}
+	properties := map[string]interface{}{}
+	required := []interface{}{}
+	for _, part := range append([]interface{}{schema}, asList(all)...) {
+		resolved := doc.merge(doc.resolve(part))
+		for k, v := range resolved {
+			if k != "allOf" && k != "properties" && k != "required" {
+				merged[k] = v
+			}
+		}
+		for k, v := range asMap(resolved["properties"]) {
+			properties[k] = v
+		}
+		required = append(required, asList(resolved["required"])...)
+	}
+
+	merged["properties"] = properties
+	merged["required"] = required
+	return merged
+}
+
+func asMap(value interface{}) map[string]interface{} {
+	m, _ := value.(map[string]interface{})
+	return m
+}
+
+func asList(value interface{}) []interface{} {
+	l, _ := value.([]interface{})
+	return l
+}
+
+func stringList(value interface{}) []string {
+	strings := []string{}
+	for _, item := range asList(value) {
+		if s, ok := item.(string); ok {
+			strings = append(strings, s)
+		}
+	}
+	return strings
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if fmt.Sprintf("%v", v) == fmt.Sprintf("%v", value) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTrue(value interface{}) bool {
+	b, _ := value.(bool)
+	return b
+}
+
+func sortedKeysOf(value interface{}) []string {
+	keys := []string{}
+	switch m := value.(type) {
+	case map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
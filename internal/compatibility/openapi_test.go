@@ -0,0 +1,156 @@
+package compatibility_test
+
+import (
+	"github.com/syncromatics/idl-repository/internal/compatibility"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const previousOpenAPI = `
+openapi: 3.0.0
+info:
+  title: vehicles
+  version: 1.0.0
+paths:
+  /vehicles:
+    get:
+      parameters:
+        - name: limit
+          in: query
+          schema:
+            type: integer
+      responses:
+        '200':
+          description: vehicles
+          content:
+            application/json:
+              schema:
+                type: array
+                items:
+                  $ref: '#/components/schemas/Vehicle'
+    post:
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Vehicle'
+      responses:
+        '201':
+          description: created
+  /vehicles/{id}:
+    parameters:
+      - name: id
+        in: path
+        required: true
+        schema:
+          type: string
+    delete:
+      responses:
+        '204':
+          description: deleted
+components:
+  schemas:
+    Vehicle:
+      type: object
+      required: [id]
+      properties:
+        id:
+          type: string
+        status:
+          type: string
+          enum: [active, retired]
+`
+
+const previousSwagger = `{
+  "swagger": "2.0",
+  "info": {"title": "positions", "version": "1.0.0"},
+  "paths": {
+    "/positions": {
+      "get": {
+        "parameters": [{"name": "vehicle", "in": "query", "type": "string"}],
+        "responses": {"200": {"description": "ok", "schema": {"$ref": "#/definitions/Position"}}}
+      }
+    }
+  },
+  "definitions": {
+    "Position": {"type": "object", "properties": {"latitude": {"type": "number"}, "longitude": {"type": "number"}}}
+  }
+}`
+
+func openAPIFiles(openapi string) compatibility.Files {
+	return compatibility.Files{"api/vehicles.yaml": []byte(openapi), "idl.yaml": []byte("name: common")}
+}
+
+var _ = Describe("openapi compatibility", func() {
+	DescribeTable("checking changes",
+		func(mode compatibility.Mode, find string, replace string, expected ...string) {
+			current := replaceOnce(previousOpenAPI, find, replace)
+
+			issues := compatibility.Check(mode, "openapi", openAPIFiles(previousOpenAPI), openAPIFiles(current))
+
+			messages := []string{}
+			for _, issue := range issues {
+				Expect(issue.File).To(Equal("api/vehicles.yaml"))
+				messages = append(messages, issue.Message)
+			}
+			Expect(messages).To(ConsistOf(expected))
+		},
+		Entry("unchanged", compatibility.Full, "", ""),
+		Entry("renamed path parameter", compatibility.Full, "/vehicles/{id}:", "/vehicles/{vehicleId}:"),
+		Entry("added optional parameter", compatibility.Backward, "        - name: limit", "        - name: offset\n          in: query\n        - name: limit"),
+		Entry("removed path", compatibility.Backward, "  /vehicles/{id}:", "  /vehicle/{id}:",
+			"path '/vehicles/{id}' was removed (backward)"),
+		Entry("removed operation", compatibility.Backward, "    delete:", "    patch:",
+			"operation 'DELETE /vehicles/{id}' was removed (backward)"),
+		Entry("newly required parameter", compatibility.Backward, "          in: query\n", "          in: query\n          required: true\n",
+			"GET /vehicles: parameter 'limit' in query is newly required (backward)"),
+		Entry("changed parameter type", compatibility.Backward, "          schema:\n            type: integer", "          schema:\n            type: string",
+			"GET /vehicles: parameter 'limit' in query changed type from 'integer' to 'string' (backward)"),
+		Entry("removed response", compatibility.Backward, "'201'", "'202'",
+			"POST /vehicles: response 201 was removed (backward)"),
+		Entry("removed response property", compatibility.Backward, "        id:\n          type: string\n", "",
+			"GET /vehicles: response 200 'application/json' field 'body[].id' was removed (backward)"),
+		Entry("response property no longer required", compatibility.Backward, "required: [id]", "required: []",
+			"GET /vehicles: response 200 'application/json' field 'body[].id' is no longer required (backward)"),
+		Entry("newly required request property", compatibility.Backward, "required: [id]", "required: [id, status]",
+			"POST /vehicles: request body field 'body.status' is newly required (backward)"),
+		Entry("narrowed request enum", compatibility.Backward, "enum: [active, retired]", "enum: [active]",
+			"POST /vehicles: request body field 'body.status' no longer accepts 'retired' (backward)"),
+		Entry("added path and operation", compatibility.Full, "  /vehicles/{id}:", "  /fleets:\n    get:\n      responses:\n        '200':\n          description: ok\n  /vehicles/{id}:\n    put:\n      responses:\n        '204':\n          description: replaced"),
+		Entry("request property no longer required", compatibility.Forward, "required: [id]", "required: []",
+			"POST /vehicles: request body field 'body.id' is no longer required (forward)"),
+		Entry("removed required request property", compatibility.Forward, "      required: [id]\n      properties:\n        id:\n          type: string\n", "      properties:\n",
+			"POST /vehicles: request body field 'body.id' that the previous version requires was removed (forward)"),
+		Entry("widened request enum", compatibility.Forward, "enum: [active, retired]", "enum: [active, retired, stolen]",
+			"POST /vehicles: request body field 'body.status' now accepts 'stolen' (forward)"),
+		Entry("newly required response property", compatibility.Forward, "required: [id]", "required: [id, status]",
+			"GET /vehicles: response 200 'application/json' field 'body[].status' is newly required (forward)"),
+	)
+
+	It("should compare swagger 2 documents", func() {
+		current := replaceOnce(previousSwagger, `"longitude": {"type": "number"}`, `"longitude": {"type": "string"}`)
+
+		issues := compatibility.Check(compatibility.Backward, "openapi",
+			compatibility.Files{"positions.json": []byte(previousSwagger)},
+			compatibility.Files{"positions.json": []byte(current)})
+
+		Expect(issues).To(Equal([]compatibility.Issue{
+			{File: "positions.json", Message: "GET /positions: response 200 field 'body.longitude' changed type from 'number' to 'string' (backward)"},
+		}))
+	})
+
+	It("should only compare documents in openapi types", func() {
+		current := replaceOnce(previousOpenAPI, "  /vehicles/{id}:", "  /vehicle/{id}:")
+
+		Expect(compatibility.Check(compatibility.Full, "proto", openAPIFiles(previousOpenAPI), openAPIFiles(current))).To(BeEmpty())
+		Expect(compatibility.Check(compatibility.Full, "openapi", openAPIFiles(previousOpenAPI), openAPIFiles(current))).ToNot(BeEmpty())
+	})
+
+	It("should report removed documents", func() {
+		issues := compatibility.Check(compatibility.Backward, "openapi", openAPIFiles(previousOpenAPI), compatibility.Files{})
+
+		Expect(issues).To(Equal([]compatibility.Issue{{File: "api/vehicles.yaml", Message: "api was removed"}}))
+	})
+})
@@ -0,0 +1,590 @@
+package compatibility
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type protoSchema struct {
+	messages map[string]*protoMessage
+	enums    map[string]*protoEnum
+}
+
+type protoMessage struct {
+	file     string
+	name     string
+	fields   map[int]*protoField
+	reserved protoReserved
+}
+
+type protoField struct {
+	name   string
+	number int
+	label  string
+	kind   string
+	oneof  string
+}
+
+type protoEnum struct {
+	file     string
+	name     string
+	values   map[int][]string
+	reserved protoReserved
+}
+
+type protoReserved struct {
+	ranges [][2]int
+	names  map[string]bool
+}
+
+type protoParser struct {
+	parser
+	file   string
+	pkg    string
+	schema *protoSchema
+}
+
+func checkProto(mode Mode, previous Files, current Files) []Issue {
+	old, issues := parseProtoFiles(previous.withExtensions(".proto"))
+	if len(issues) > 0 {
+		// the previous version was accepted already, it is only used for comparison
+		old = &protoSchema{map[string]*protoMessage{}, map[string]*protoEnum{}}
+	}
+
+	new, issues := parseProtoFiles(current.withExtensions(".proto"))
+	if len(issues) > 0 {
+		return issues
+	}
+
+	return compareProto(mode, old, new)
+}
+
+func parseProtoFiles(files Files) (*protoSchema, []Issue) {
+	schema := &protoSchema{
+		messages: map[string]*protoMessage{},
+		enums:    map[string]*protoEnum{},
+	}
+
+	issues := []Issue{}
+	for _, name := range files.names() {
+		err := parseProto(name, files[name], schema)
+		if err != nil {
+			issues = append(issues, Issue{name, fmt.Sprintf("failed to parse: %s", err)})
+		}
+	}
+
+	return schema, issues
+}
+
+func parseProto(file string, source []byte, schema *protoSchema) error {
+	tokens, err := lex(source)
+	if err != nil {
+		return err
+	}
+
+	p := &protoParser{parser: parser{tokens: tokens}, file: file, schema: schema}
+	for p.peek().kind != eofToken {
+		t := p.next()
+		switch t.text {
+		case "package":
+			p.pkg, err = p.ident()
+			if err == nil {
+				err = p.expect(";")
+			}
+		case "message":
+			err = p.message(p.pkg)
+		case "enum":
+			err = p.enum(p.pkg)
+		case "service", "extend":
+			_, err = p.ident()
+			if err == nil {
+				err = p.skipBlock()
+			}
+		case "syntax", "import", "option", "edition":
+			err = p.skipStatement()
+		case ";":
+		default:
+			err = p.unexpected(t, "a definition")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func qualify(scope string, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func (p *protoParser) message(scope string) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+
+	message := &protoMessage{
+		file:     p.file,
+		name:     qualify(scope, name),
+		fields:   map[int]*protoField{},
+		reserved: protoReserved{names: map[string]bool{}},
+	}
+	p.schema.messages[message.name] = message
+
+	err = p.expect("{")
+	if err != nil {
+		return err
+	}
+
+	return p.messageBody(message, "")
+}
+
+func (p *protoParser) messageBody(message *protoMessage, oneof string) error {
+	for {
+		t := p.peek()
+		var err error
+		switch {
+		case t.kind == eofToken:
+			return p.unexpected(t, "'}'")
+		case t.text == "}":
+			p.next()
+			return nil
+		case t.text == ";":
+			p.next()
+		case t.text == "message" && oneof == "":
+			p.next()
+			err = p.message(message.name)
+		case t.text == "enum" && oneof == "":
+			p.next()
+			err = p.enum(message.name)
+		case t.text == "oneof" && oneof == "":
+			p.next()
+			var name string
+			name, err = p.ident()
+			if err == nil {
+				err = p.expect("{")
+			}
+			if err == nil {
+				err = p.messageBody(message, name)
+			}
+		case t.text == "reserved":
+			p.next()
+			err = p.reserved(&message.reserved)
+		case t.text == "option" || t.text == "extensions":
+			err = p.skipStatement()
+		case t.text == "extend":
+			p.next()
+			_, err = p.ident()
+			if err == nil {
+				err = p.skipBlock()
+			}
+		default:
+			err = p.field(message, oneof)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *protoParser) field(message *protoMessage, oneof string) error {
+	field := &protoField{oneof: oneof}
+
+	switch p.peek().text {
+	case "optional", "required", "repeated":
+		field.label = p.next().text
+	}
+
+	if p.accept("map") {
+		err := p.expect("<")
+		if err != nil {
+			return err
+		}
+		key, err := p.ident()
+		if err != nil {
+			return err
+		}
+		err = p.expect(",")
+		if err != nil {
+			return err
+		}
+		value, err := p.ident()
+		if err != nil {
+			return err
+		}
+		err = p.expect(">")
+		if err != nil {
+			return err
+		}
+		field.kind = fmt.Sprintf("map<%s, %s>", key, p.normalize(value))
+	} else {
+		kind, err := p.ident()
+		if err != nil {
+			return err
+		}
+		field.kind = p.normalize(kind)
+	}
+
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	field.name = name
+
+	err = p.expect("=")
+	if err != nil {
+		return err
+	}
+
+	number := p.next()
+	n, err := strconv.ParseInt(number.text, 0, 32)
+	if err != nil {
+		return p.unexpected(number, "a field number")
+	}
+	field.number = int(n)
+
+	if field.kind == "group" {
+		// proto2 groups declare their fields inline
+		if p.peek().text == "[" {
+			err = p.skipOptions()
+			if err != nil {
+				return err
+			}
+		}
+		err = p.skipBlock()
+		if err != nil {
+			return err
+		}
+	} else {
+		err = p.skipStatement()
+		if err != nil {
+			return err
+		}
+	}
+
+	message.fields[field.number] = field
+	return nil
+}
+
+func (p *protoParser) skipOptions() error {
+	depth := 0
+	for {
+		t := p.next()
+		switch {
+		case t.kind == eofToken:
+			return p.unexpected(t, "']'")
+		case t.text == "[":
+			depth++
+		case t.text == "]":
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// normalize drops the package from type names so "Foo" and ".pkg.Foo" compare equal
+func (p *protoParser) normalize(kind string) string {
+	kind = strings.TrimPrefix(kind, ".")
+	if p.pkg != "" {
+		kind = strings.TrimPrefix(kind, p.pkg+".")
+	}
+	return kind
+}
+
+func (p *protoParser) enum(scope string) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+
+	enum := &protoEnum{
+		file:     p.file,
+		name:     qualify(scope, name),
+		values:   map[int][]string{},
+		reserved: protoReserved{names: map[string]bool{}},
+	}
+	p.schema.enums[enum.name] = enum
+
+	err = p.expect("{")
+	if err != nil {
+		return err
+	}
+
+	for {
+		t := p.next()
+		switch {
+		case t.kind == eofToken:
+			return p.unexpected(t, "'}'")
+		case t.text == "}":
+			return nil
+		case t.text == ";":
+		case t.text == "option":
+			err = p.skipStatement()
+		case t.text == "reserved":
+			err = p.reserved(&enum.reserved)
+		case t.kind == identToken:
+			err = p.expect("=")
+			if err != nil {
+				return err
+			}
+
+			number := p.next()
+			n, convErr := strconv.ParseInt(number.text, 0, 32)
+			if convErr != nil {
+				return p.unexpected(number, "an enum value")
+			}
+			enum.values[int(n)] = append(enum.values[int(n)], t.text)
+
+			err = p.skipStatement()
+		default:
+			err = p.unexpected(t, "an enum value")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *protoParser) reserved(reserved *protoReserved) error {
+	for {
+		t := p.next()
+		switch {
+		case t.kind == stringToken:
+			reserved.names[t.text] = true
+		case t.kind == identToken:
+			// editions reserve names without quotes
+			reserved.names[t.text] = true
+		case t.kind == numberToken:
+			start, err := strconv.ParseInt(t.text, 0, 32)
+			if err != nil {
+				return p.unexpected(t, "a reserved number")
+			}
+
+			end := start
+			if p.accept("to") {
+				e := p.next()
+				if e.text == "max" {
+					end = 536870911
+				} else {
+					end, err = strconv.ParseInt(e.text, 0, 32)
+					if err != nil {
+						return p.unexpected(e, "a reserved number")
+					}
+				}
+			}
+			reserved.ranges = append(reserved.ranges, [2]int{int(start), int(end)})
+		default:
+			return p.unexpected(t, "a reserved number or name")
+		}
+
+		if p.accept(";") {
+			return nil
+		}
+
+		err := p.expect(",")
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r protoReserved) number(n int) bool {
+	for _, rng := range r.ranges {
+		if n >= rng[0] && n <= rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func compareProto(mode Mode, old *protoSchema, new *protoSchema) []Issue {
+	issues := []Issue{}
+
+	// removed types break readers and writers alike
+	for _, name := range sortedKeys(old.messages) {
+		before := old.messages[name]
+		after, ok := new.messages[name]
+		if !ok {
+			issues = append(issues, Issue{before.file, fmt.Sprintf("message '%s' was removed", name)})
+			continue
+		}
+
+		issues = append(issues, compareMessages(mode, before, after)...)
+	}
+
+	for _, name := range sortedEnumKeys(old.enums) {
+		before := old.enums[name]
+		after, ok := new.enums[name]
+		if !ok {
+			issues = append(issues, Issue{before.file, fmt.Sprintf("enum '%s' was removed", name)})
+			continue
+		}
+
+		issues = append(issues, compareEnums(before, after)...)
+	}
+
+	return issues
+}
+
+func compareMessages(mode Mode, before *protoMessage, after *protoMessage) []Issue {
+	issues := []Issue{}
+	add := func(format string, args ...interface{}) {
+		issues = append(issues, Issue{after.file, fmt.Sprintf("message '%s': ", after.name) + fmt.Sprintf(format, args...)})
+	}
+
+	byName := map[string]*protoField{}
+	for _, f := range after.fields {
+		byName[f.name] = f
+	}
+
+	for _, number := range sortedNumbers(before.fields) {
+		old := before.fields[number]
+		new, ok := after.fields[number]
+		if !ok {
+			moved, renumbered := byName[old.name]
+			switch {
+			case renumbered:
+				add("field '%s' changed number from %d to %d", old.name, old.number, moved.number)
+			case !after.reserved.number(old.number):
+				add("field '%s' (%d) was removed without reserving its number", old.name, old.number)
+			}
+
+			if old.label == "required" && mode.forward() {
+				add("required field '%s' (%d) was removed", old.name, old.number)
+			}
+			continue
+		}
+
+		if old.name != new.name {
+			add("field %d was renamed from '%s' to '%s'", number, old.name, new.name)
+		}
+		if old.kind != new.kind {
+			add("field '%s' (%d) changed type from '%s' to '%s'", new.name, number, old.kind, new.kind)
+		}
+		if (old.label == "repeated") != (new.label == "repeated") {
+			add("field '%s' (%d) changed between repeated and singular", new.name, number)
+		}
+		if old.oneof != new.oneof {
+			add("field '%s' (%d) moved from oneof '%s' to '%s'", new.name, number, old.oneof, new.oneof)
+		}
+	}
+
+	for _, number := range sortedNumbers(after.fields) {
+		new := after.fields[number]
+		if _, existed := before.fields[number]; existed {
+			continue
+		}
+
+		if before.reserved.number(number) || before.reserved.names[new.name] {
+			add("field '%s' (%d) reuses a reserved number or name", new.name, number)
+		}
+		if new.label == "required" && mode.backward() {
+			add("required field '%s' (%d) was added", new.name, number)
+		}
+	}
+
+	return issues
+}
+
+func compareEnums(before *protoEnum, after *protoEnum) []Issue {
+	issues := []Issue{}
+	add := func(format string, args ...interface{}) {
+		issues = append(issues, Issue{after.file, fmt.Sprintf("enum '%s': ", after.name) + fmt.Sprintf(format, args...)})
+	}
+
+	byName := map[string]int{}
+	for _, number := range sortedValueNumbers(after.values) {
+		for _, name := range after.values[number] {
+			byName[name] = number
+		}
+	}
+
+	for _, number := range sortedValueNumbers(before.values) {
+		oldNames := before.values[number]
+		newNames, ok := after.values[number]
+		if !ok {
+			moved, renumbered := byName[oldNames[0]]
+			switch {
+			case renumbered:
+				add("value '%s' changed number from %d to %d", oldNames[0], number, moved)
+			case !after.reserved.number(number):
+				add("value '%s' (%d) was removed without reserving its number", oldNames[0], number)
+			}
+			continue
+		}
+
+		for _, name := range oldNames {
+			if !containsString(newNames, name) {
+				add("value %d was renamed from '%s' to '%s'", number, name, newNames[0])
+			}
+		}
+	}
+
+	for _, number := range sortedValueNumbers(after.values) {
+		names := after.values[number]
+		if _, existed := before.values[number]; existed {
+			continue
+		}
+		if before.reserved.number(number) || before.reserved.names[names[0]] {
+			add("value '%s' (%d) reuses a reserved number or name", names[0], number)
+		}
+	}
+
+	return issues
+}
+
+func sortedKeys(m map[string]*protoMessage) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedEnumKeys(m map[string]*protoEnum) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedNumbers(m map[int]*protoField) []int {
+	numbers := []int{}
+	for n := range m {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+func sortedValueNumbers(m map[int][]string) []int {
+	numbers := []int{}
+	for n := range m {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
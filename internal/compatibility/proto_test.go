@@ -0,0 +1,148 @@
+package compatibility_test
+
+import (
+	"github.com/syncromatics/idl-repository/internal/compatibility"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const previousProto = `
+syntax = "proto3";
+package example.v1;
+
+import "google/protobuf/timestamp.proto";
+
+option go_package = "example/v1";
+
+// a vehicle position
+message Position {
+  string vehicle_id = 1;
+  double latitude = 2 [deprecated = true];
+  double longitude = 3;
+  repeated string tags = 4;
+  map<string, Status> statuses = 5;
+  oneof source {
+    string gps = 6;
+    string manual = 7;
+  }
+  google.protobuf.Timestamp time = 8;
+
+  reserved 10 to 12;
+  reserved "old_name";
+
+  message Accuracy {
+    float meters = 1;
+  }
+}
+
+enum Status {
+  STATUS_UNKNOWN = 0;
+  STATUS_ACTIVE = 1;
+  STATUS_RETIRED = 2;
+}
+
+service Positions {
+  rpc Get(Position) returns (Position) {
+    option (google.api.http) = { get: "/v1/positions" };
+  }
+}
+`
+
+func protoFiles(source string) compatibility.Files {
+	return compatibility.Files{"example/v1/position.proto": []byte(source)}
+}
+
+var _ = Describe("protobuf compatibility", func() {
+	DescribeTable("checking changes",
+		func(mode compatibility.Mode, find string, replace string, expected string) {
+			current := replaceOnce(previousProto, find, replace)
+
+			issues := compatibility.Check(mode, "proto", protoFiles(previousProto), protoFiles(current))
+			if expected == "" {
+				Expect(issues).To(BeEmpty())
+				return
+			}
+
+			Expect(issues).To(HaveLen(1))
+			Expect(issues[0].File).To(Equal("example/v1/position.proto"))
+			Expect(issues[0].Message).To(Equal(expected))
+		},
+		Entry("unchanged", compatibility.Full, "", "", ""),
+		Entry("added field", compatibility.Full, "  google.protobuf.Timestamp time = 8;", "  google.protobuf.Timestamp time = 8;\n  string driver = 9;", ""),
+		Entry("removed field with reserved number", compatibility.Full, "  double longitude = 3;", "  reserved 3;", ""),
+		Entry("fully qualified type", compatibility.Full, "map<string, Status>", "map<string, .example.v1.Status>", ""),
+		Entry("no checks", compatibility.None, "string vehicle_id = 1;", "int32 vehicle_id = 1;", ""),
+		Entry("changed type", compatibility.Backward, "string vehicle_id = 1;", "int32 vehicle_id = 1;",
+			"message 'example.v1.Position': field 'vehicle_id' (1) changed type from 'string' to 'int32'"),
+		Entry("changed number", compatibility.Forward, "double longitude = 3;", "double longitude = 9;",
+			"message 'example.v1.Position': field 'longitude' changed number from 3 to 9"),
+		Entry("removed field", compatibility.Backward, "  double longitude = 3;\n", "",
+			"message 'example.v1.Position': field 'longitude' (3) was removed without reserving its number"),
+		Entry("renamed field", compatibility.Backward, "double longitude = 3;", "double lng = 3;",
+			"message 'example.v1.Position': field 3 was renamed from 'longitude' to 'lng'"),
+		Entry("repeated field made singular", compatibility.Backward, "repeated string tags = 4;", "string tags = 4;",
+			"message 'example.v1.Position': field 'tags' (4) changed between repeated and singular"),
+		Entry("field moved out of oneof", compatibility.Backward, "    string manual = 7;\n  }", "  }\n  string manual = 7;",
+			"message 'example.v1.Position': field 'manual' (7) moved from oneof 'source' to ''"),
+		Entry("reused reserved number", compatibility.Backward, "  google.protobuf.Timestamp time = 8;", "  google.protobuf.Timestamp time = 8;\n  string driver = 11;",
+			"message 'example.v1.Position': field 'driver' (11) reuses a reserved number or name"),
+		Entry("removed message", compatibility.Backward, "  message Accuracy {\n    float meters = 1;\n  }\n", "",
+			"message 'example.v1.Position.Accuracy' was removed"),
+		Entry("renamed enum value", compatibility.Backward, "STATUS_RETIRED = 2;", "STATUS_GONE = 2;",
+			"enum 'example.v1.Status': value 2 was renamed from 'STATUS_RETIRED' to 'STATUS_GONE'"),
+		Entry("removed enum value", compatibility.Forward, "  STATUS_RETIRED = 2;\n", "",
+			"enum 'example.v1.Status': value 'STATUS_RETIRED' (2) was removed without reserving its number"),
+	)
+
+	It("should only check added required fields backward", func() {
+		previous := protoFiles(`syntax = "proto2"; message A { optional string a = 1; }`)
+		current := protoFiles(`syntax = "proto2"; message A { optional string a = 1; required string b = 2; }`)
+
+		Expect(compatibility.Check(compatibility.Forward, "proto", previous, current)).To(BeEmpty())
+		Expect(compatibility.Check(compatibility.Backward, "proto", previous, current)).To(HaveLen(1))
+	})
+
+	It("should report enum values in order of their numbers", func() {
+		previous := protoFiles(`enum E { A = 0; reserved 3 to 9; }`)
+		current := protoFiles(`enum E { A = 0; I = 9; H = 8; G = 7; F = 6; E = 5; D = 4; C = 3; }`)
+
+		messages := []string{}
+		for _, issue := range compatibility.Check(compatibility.Forward, "proto", previous, current) {
+			messages = append(messages, issue.Message)
+		}
+		Expect(messages).To(Equal([]string{
+			"enum 'E': value 'C' (3) reuses a reserved number or name",
+			"enum 'E': value 'D' (4) reuses a reserved number or name",
+			"enum 'E': value 'E' (5) reuses a reserved number or name",
+			"enum 'E': value 'F' (6) reuses a reserved number or name",
+			"enum 'E': value 'G' (7) reuses a reserved number or name",
+			"enum 'E': value 'H' (8) reuses a reserved number or name",
+			"enum 'E': value 'I' (9) reuses a reserved number or name",
+		}))
+	})
+
+	It("should read hex and octal numbers", func() {
+		previous := protoFiles(`message A { int32 a = 0x10; int32 b = 010; reserved 0x20 to 0x22; }`)
+		current := protoFiles(`message A { int32 a = 16; int32 b = 8; reserved 0x20 to 0x22; string c = 0x21; }`)
+
+		issues := compatibility.Check(compatibility.Full, "proto", previous, current)
+		Expect(issues).To(HaveLen(1))
+		Expect(issues[0].Message).To(Equal("message 'A': field 'c' (33) reuses a reserved number or name"))
+	})
+
+	It("should report files that do not parse", func() {
+		issues := compatibility.Check(compatibility.Backward, "proto", protoFiles(previousProto), protoFiles(`message A { string a = ; }`))
+
+		Expect(issues).To(HaveLen(1))
+		Expect(issues[0].Message).To(Equal("failed to parse: line 1: expected a field number but found ';'"))
+	})
+
+	It("should ignore files that are not schemas", func() {
+		previous := compatibility.Files{"README.md": []byte("hello")}
+		current := compatibility.Files{"README.md": []byte("goodbye")}
+
+		Expect(compatibility.Check(compatibility.Full, "proto", previous, current)).To(BeEmpty())
+	})
+})
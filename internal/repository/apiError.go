@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/rs/xid"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+// requestIDs passed in by proxies are kept when they are reasonable
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// apiError is the body of every error response
+type apiError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"request_id"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+// detailedError is implemented by response models that are more than a message
+type detailedError interface {
+	error
+	errorCode() string
+	errorDetails() interface{}
+}
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusInternalServerError:   "internal",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// setRequestID names the request in the response headers, which is where error responses read it from
+func setRequestID(w http.ResponseWriter, req *http.Request) string {
+	id := req.Header.Get(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = xid.New().String()
+	}
+
+	w.Header().Set(requestIDHeader, id)
+	return id
+}
+
+func newAPIError(statusCode int, requestID string, model interface{}) apiError {
+	e := apiError{
+		Code:      errorCodes[statusCode],
+		RequestID: requestID,
+	}
+	if e.Code == "" {
+		e.Code = fmt.Sprintf("status_%d", statusCode)
+	}
+
+	switch m := model.(type) {
+	case detailedError:
+		e.Code = m.errorCode()
+		e.Message = m.Error()
+		e.Details = m.errorDetails()
+	case string:
+		e.Message = m
+	default:
+		e.Message = http.StatusText(statusCode)
+		e.Details = m
+	}
+	return e
+}
+
+func writeError(w http.ResponseWriter, statusCode int, model interface{}) {
+	b, _ := json.Marshal(newAPIError(statusCode, w.Header().Get(requestIDHeader), model))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(b)
+}
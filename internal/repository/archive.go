@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// invalidArchiveError describes why an upload was rejected, it is returned to clients as is
+type invalidArchiveError struct {
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Entry   string `json:"entry,omitempty"`
+}
+
+func (e *invalidArchiveError) Error() string {
+	return e.Message
+}
+
+func (e *invalidArchiveError) errorCode() string {
+	return "invalid_archive"
+}
+
+func (e *invalidArchiveError) errorDetails() interface{} {
+	return struct {
+		Reason string `json:"reason"`
+		Entry  string `json:"entry,omitempty"`
+	}{e.Reason, e.Entry}
+}
+
+func invalidArchive(reason string, format string, args ...interface{}) *invalidArchiveError {
+	return &invalidArchiveError{Message: fmt.Sprintf(format, args...), Reason: reason}
+}
+
+func invalidEntry(header *tar.Header, reason string, format string, args ...interface{}) *invalidArchiveError {
+	return &invalidArchiveError{
+		Message: fmt.Sprintf("archive entry '%s' ", header.Name) + fmt.Sprintf(format, args...),
+		Reason:  reason,
+		Entry:   header.Name,
+	}
+}
+
+// archiveLimits caps the uncompressed size of archives, zero means no limit
+type archiveLimits struct {
+	file  int64
+	total int64
+}
+
+func (r *projectRouter) archiveLimits() archiveLimits {
+	return archiveLimits{file: r.settings.MaxFileSize, total: r.settings.MaxArchiveSize}
+}
+
+// archiveValidator passes an upload through while checking that it is a complete gzipped tar.
+// The final read fails if the archive is not valid so storage never commits it.
+type archiveValidator struct {
+	source io.Reader
+	pipe   *io.PipeWriter
+	result chan error
+	err    error
+	done   bool
+}
+
+func newArchiveValidator(source io.Reader, limits archiveLimits) *archiveValidator {
+	pr, pw := io.Pipe()
+	v := &archiveValidator{
+		source: source,
+		pipe:   pw,
+		result: make(chan error, 1),
+	}
+
+	go func() {
+		err := checkArchive(pr, limits)
+		if err != nil {
+			pr.CloseWithError(err)
+		} else {
+			io.Copy(ioutil.Discard, pr)
+		}
+		v.result <- err
+	}()
+
+	return v
+}
+
+func (v *archiveValidator) Read(p []byte) (int, error) {
+	if v.done {
+		if v.err != nil {
+			return 0, v.err
+		}
+		return 0, io.EOF
+	}
+
+	n, err := v.source.Read(p)
+	if n > 0 {
+		_, werr := v.pipe.Write(p[:n])
+		if werr != nil {
+			return 0, v.wait()
+		}
+	}
+
+	switch {
+	case err == io.EOF:
+		v.pipe.Close()
+		verr := v.wait()
+		if verr != nil {
+			return 0, verr
+		}
+	case err != nil:
+		v.pipe.CloseWithError(err)
+		v.wait()
+	}
+
+	return n, err
+}
+
+func (v *archiveValidator) wait() error {
+	if !v.done {
+		v.err = <-v.result
+		v.done = true
+	}
+	return v.err
+}
+
+func checkArchive(r io.Reader, limits archiveLimits) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return invalidArchive("not-gzip", "archive is not gzipped: %s", err)
+	}
+
+	total := int64(0)
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return invalidArchive("not-tar", "archive is not a valid tar: %s", err)
+		}
+
+		err = checkEntry(header)
+		if err != nil {
+			return err
+		}
+
+		if limits.file > 0 && header.Size > limits.file {
+			return invalidEntry(header, "file-too-large", "is %d bytes, more than the limit of %d bytes", header.Size, limits.file)
+		}
+
+		total += header.Size
+		if limits.total > 0 && total > limits.total {
+			return invalidEntry(header, "archive-too-large", "takes the archive over the limit of %d bytes", limits.total)
+		}
+
+		_, err = io.Copy(ioutil.Discard, tr)
+		if err != nil {
+			return invalidArchive("incomplete", "archive is incomplete: %s", err)
+		}
+	}
+
+	// read to the end of the gzip stream so its checksum is verified
+	_, err = io.Copy(ioutil.Discard, gzr)
+	if err != nil {
+		return invalidArchive("incomplete", "archive is incomplete: %s", err)
+	}
+
+	return nil
+}
+
+// checkEntry only lets through files and directories that stay inside the archive
+func checkEntry(header *tar.Header) error {
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeDir, tar.TypeXGlobalHeader:
+	case tar.TypeSymlink:
+		return invalidEntry(header, "symlink", "is a symbolic link to '%s'", header.Linkname)
+	case tar.TypeLink:
+		return invalidEntry(header, "hardlink", "is a hard link to '%s'", header.Linkname)
+	case tar.TypeChar, tar.TypeBlock:
+		return invalidEntry(header, "device", "is a device file")
+	default:
+		return invalidEntry(header, "unsupported-entry", "has unsupported type '%c'", header.Typeflag)
+	}
+
+	name := strings.Replace(header.Name, "\\", "/", -1)
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return invalidEntry(header, "absolute-path", "has an absolute path")
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return invalidEntry(header, "path-traversal", "escapes the archive with '..'")
+		}
+	}
+
+	return nil
+}
+
+func isInvalidArchive(err error) (*invalidArchiveError, bool) {
+	invalid, ok := errors.Cause(err).(*invalidArchiveError)
+	return invalid, ok
+}
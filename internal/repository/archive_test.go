@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func testArchive(files map[string]string) []byte {
+	buf := new(bytes.Buffer)
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gzw.Close()
+	return buf.Bytes()
+}
+
+func testEntries(headers ...tar.Header) []byte {
+	buf := new(bytes.Buffer)
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+	for _, header := range headers {
+		header.Mode = 0644
+		tw.WriteHeader(&header)
+	}
+	tw.Close()
+	gzw.Close()
+	return buf.Bytes()
+}
+
+var _ = Describe("archiveValidator", func() {
+	It("should pass valid archives through", func() {
+		archive := testArchive(map[string]string{"a.proto": "syntax = \"proto3\";"})
+
+		b, err := ioutil.ReadAll(newArchiveValidator(bytes.NewReader(archive), archiveLimits{}))
+		Expect(err).To(BeNil())
+		Expect(b).To(Equal(archive))
+	})
+
+	It("should reject data that is not gzipped", func() {
+		_, err := ioutil.ReadAll(newArchiveValidator(bytes.NewReader([]byte("not an archive")), archiveLimits{}))
+
+		_, ok := isInvalidArchive(err)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should reject truncated archives", func() {
+		archive := testArchive(map[string]string{"a.proto": "syntax = \"proto3\";"})
+
+		_, err := ioutil.ReadAll(newArchiveValidator(bytes.NewReader(archive[:len(archive)-10]), archiveLimits{}))
+
+		invalid, ok := isInvalidArchive(err)
+		Expect(ok).To(BeTrue())
+		Expect(invalid.Reason).To(Equal("incomplete"))
+	})
+
+	DescribeTable("rejecting unsafe entries",
+		func(header tar.Header, reason string, message string) {
+			_, err := ioutil.ReadAll(newArchiveValidator(bytes.NewReader(testEntries(header)), archiveLimits{}))
+
+			invalid, ok := isInvalidArchive(err)
+			Expect(ok).To(BeTrue())
+			Expect(invalid.Reason).To(Equal(reason))
+			Expect(invalid.Entry).To(Equal(header.Name))
+			Expect(invalid.Message).To(Equal(message))
+		},
+		Entry("absolute path", tar.Header{Name: "/etc/passwd", Typeflag: tar.TypeReg}, "absolute-path", "archive entry '/etc/passwd' has an absolute path"),
+		Entry("windows path", tar.Header{Name: "C:\\Windows\\a.proto", Typeflag: tar.TypeReg}, "absolute-path", "archive entry 'C:\\Windows\\a.proto' has an absolute path"),
+		Entry("traversal", tar.Header{Name: "a/../../b.proto", Typeflag: tar.TypeReg}, "path-traversal", "archive entry 'a/../../b.proto' escapes the archive with '..'"),
+		Entry("symlink", tar.Header{Name: "a.proto", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}, "symlink", "archive entry 'a.proto' is a symbolic link to '/etc/passwd'"),
+		Entry("hard link", tar.Header{Name: "a.proto", Typeflag: tar.TypeLink, Linkname: "b.proto"}, "hardlink", "archive entry 'a.proto' is a hard link to 'b.proto'"),
+		Entry("device", tar.Header{Name: "null", Typeflag: tar.TypeChar}, "device", "archive entry 'null' is a device file"),
+		Entry("fifo", tar.Header{Name: "pipe", Typeflag: tar.TypeFifo}, "unsupported-entry", "archive entry 'pipe' has unsupported type '6'"),
+	)
+
+	It("should enforce size limits", func() {
+		archive := testArchive(map[string]string{"a.proto": "0123456789", "b.proto": "0123456789"})
+
+		_, err := ioutil.ReadAll(newArchiveValidator(bytes.NewReader(archive), archiveLimits{file: 10, total: 20}))
+		Expect(err).To(BeNil())
+
+		_, err = ioutil.ReadAll(newArchiveValidator(bytes.NewReader(archive), archiveLimits{file: 9}))
+		invalid, _ := isInvalidArchive(err)
+		Expect(invalid.Reason).To(Equal("file-too-large"))
+
+		_, err = ioutil.ReadAll(newArchiveValidator(bytes.NewReader(archive), archiveLimits{total: 15}))
+		invalid, _ = isInvalidArchive(err)
+		Expect(invalid.Reason).To(Equal("archive-too-large"))
+	})
+})
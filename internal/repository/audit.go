@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	auditRoot = "/audit"
+	// entries are named by time so listing them sorts them
+	auditTimeFormat = "20060102T150405.000000000Z"
+
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Project   string    `json:"project"`
+	Type      string    `json:"type,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	Digest    string    `json:"digest,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// audit stores each entry as its own file so records are only ever added. It runs once the
+// change is done, so an entry that cannot be stored is logged as an error instead of failing
+// a request whose change already happened.
+func (r *projectRouter) audit(ctx HttpContext, entry auditEntry) {
+	entry.Time = time.Now().UTC()
+	entry.Actor = ctx.Identity.name()
+	entry.RequestID = ctx.RequestID
+
+	logger := r.settings.logger().WithFields(logrus.Fields{
+		"audit":      entry.Action,
+		"actor":      entry.Actor,
+		"project":    entry.Project,
+		"type":       entry.Type,
+		"version":    entry.Version,
+		"digest":     entry.Digest,
+		"request_id": entry.RequestID,
+	})
+
+	err := r.writeAuditEntry(entry)
+	if err != nil {
+		logger.WithError(err).Error("audit")
+		return
+	}
+
+	logger.Info("audit")
+}
+
+func (r *projectRouter) writeAuditEntry(entry auditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode audit entry")
+	}
+
+	err = r.storage.MkDir(auditRoot)
+	if err != nil {
+		return err
+	}
+
+	pth := fmt.Sprintf("%s/%s-%s.json", auditRoot, entry.Time.Format(auditTimeFormat), xid.New())
+	err = r.storage.CreateFile(pth, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "failed to write audit entry")
+	}
+	return nil
+}
+
+// listAudit returns the newest audit entries matching the query, only admins may read them
+func (r *projectRouter) listAudit(ctx HttpContext) (*JsonResponse, error) {
+	if ctx.Identity == nil {
+		return &JsonResponse{
+			StatusCode: 401,
+			Model:      "authentication required",
+		}, nil
+	}
+	if !ctx.Identity.Admin {
+		return &JsonResponse{
+			StatusCode: 403,
+			Model:      "only admins can read the audit log",
+		}, nil
+	}
+
+	var since, until time.Time
+	var err error
+	if value := ctx.Query.Get("since"); value != "" {
+		since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return &JsonResponse{
+				StatusCode: 400,
+				Model:      fmt.Sprintf("since '%s' is not an RFC 3339 time", value),
+			}, nil
+		}
+	}
+	if value := ctx.Query.Get("until"); value != "" {
+		until, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return &JsonResponse{
+				StatusCode: 400,
+				Model:      fmt.Sprintf("until '%s' is not an RFC 3339 time", value),
+			}, nil
+		}
+	}
+
+	limit := defaultAuditLimit
+	if value := ctx.Query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			return &JsonResponse{
+				StatusCode: 400,
+				Model:      fmt.Sprintf("limit must be a number from 1 to %d", maxAuditLimit),
+			}, nil
+		}
+	}
+
+	files, err := r.storage.ListFiles(auditRoot)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	entries := []auditEntry{}
+	for _, file := range files {
+		if len(entries) == limit {
+			break
+		}
+
+		// the name holds the time so entries outside of the range are never read
+		if written, err := time.Parse(auditTimeFormat, strings.SplitN(file, "-", 2)[0]); err == nil {
+			if !until.IsZero() && written.After(until) {
+				continue
+			}
+			if !since.IsZero() && written.Before(since) {
+				break
+			}
+		}
+
+		entry, err := r.readAuditEntry(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if matches(ctx, entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return &JsonResponse{
+		StatusCode: 200,
+		Model:      entries,
+	}, nil
+}
+
+func (r *projectRouter) readAuditEntry(file string) (auditEntry, error) {
+	entry := auditEntry{}
+
+	f, err := r.storage.ReadFile(auditRoot + "/" + file)
+	if err != nil {
+		return entry, err
+	}
+	defer f.Close()
+
+	err = json.NewDecoder(f).Decode(&entry)
+	if err != nil {
+		return entry, errors.Wrapf(err, "failed to decode audit entry '%s'", file)
+	}
+	return entry, nil
+}
+
+func matches(ctx HttpContext, entry auditEntry) bool {
+	filters := map[string]string{
+		"project": entry.Project,
+		"type":    entry.Type,
+		"version": entry.Version,
+		"action":  entry.Action,
+		"actor":   entry.Actor,
+	}
+
+	for name, value := range filters {
+		wanted := ctx.Query.Get(name)
+		if wanted != "" && wanted != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+type AuthSettings struct {
+	AnonymousRead bool            `yaml:"anonymous_read"`
+	Tokens        []StaticToken   `yaml:"tokens"`
+	JWT           *JWTSettings    `yaml:"jwt"`
+	Projects      []ProjectAccess `yaml:"projects"`
+}
+
+type StaticToken struct {
+	Name   string   `yaml:"name"`
+	Token  string   `yaml:"token"`
+	Groups []string `yaml:"groups"`
+	Admin  bool     `yaml:"admin"`
+}
+
+type JWTSettings struct {
+	Secret        string `yaml:"secret"`
+	PublicKeyFile string `yaml:"public_key_file"`
+	Issuer        string `yaml:"issuer"`
+	Audience      string `yaml:"audience"`
+	GroupsClaim   string `yaml:"groups_claim"`
+	AdminGroup    string `yaml:"admin_group"`
+}
+
+// ProjectAccess grants the listed token names or groups access to projects matching the pattern.
+// An empty read list leaves reads to the anonymous_read setting.
+type ProjectAccess struct {
+	Project string   `yaml:"project"`
+	Read    []string `yaml:"read"`
+	Write   []string `yaml:"write"`
+}
+
+type Identity struct {
+	Name   string
+	Groups []string
+	Admin  bool
+}
+
+type permission int
+
+const (
+	readPermission permission = iota
+	writePermission
+)
+
+func LoadAuthSettings(reader io.Reader) (*AuthSettings, error) {
+	settings := &AuthSettings{}
+
+	err := yaml.NewDecoder(reader).Decode(settings)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode auth settings")
+	}
+
+	for _, token := range settings.Tokens {
+		if token.Name == "" || token.Token == "" {
+			return nil, errors.New("auth tokens require a name and a token")
+		}
+	}
+
+	return settings, nil
+}
+
+type authenticator struct {
+	settings   *AuthSettings
+	adminToken string
+	jwt        *jwtVerifier
+}
+
+func newAuthenticator(settings *Settings) (*authenticator, error) {
+	a := &authenticator{
+		settings:   settings.Auth,
+		adminToken: settings.AdminToken,
+	}
+
+	if a.settings != nil && a.settings.JWT != nil {
+		verifier, err := newJWTVerifier(a.settings.JWT)
+		if err != nil {
+			return nil, err
+		}
+		a.jwt = verifier
+	}
+
+	return a, nil
+}
+
+// authenticate returns the identity of the caller, nil for anonymous callers
+func (a *authenticator) authenticate(r *http.Request) (*Identity, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, errors.New("only bearer tokens are supported")
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+
+	if a.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) == 1 {
+		return &Identity{Name: "admin", Admin: true}, nil
+	}
+
+	if a.settings == nil {
+		// without auth settings only the admin token means anything
+		return nil, nil
+	}
+
+	for _, t := range a.settings.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			return &Identity{Name: t.Name, Groups: t.Groups, Admin: t.Admin}, nil
+		}
+	}
+
+	if a.jwt != nil && strings.Count(token, ".") == 2 {
+		return a.jwt.verify(token)
+	}
+
+	return nil, errors.New("invalid token")
+}
+
+func (a *authenticator) authorize(identity *Identity, project string, needs permission) bool {
+	if a.settings == nil {
+		return true
+	}
+
+	if identity != nil && identity.Admin {
+		return true
+	}
+
+	access, found := a.projectAccess(project)
+
+	switch needs {
+	case readPermission:
+		if found && len(access.Read) > 0 {
+			return identity.matches(access.Read)
+		}
+		return a.settings.AnonymousRead || identity != nil
+
+	case writePermission:
+		return found && identity.matches(access.Write)
+	}
+
+	return false
+}
+
+func (a *authenticator) projectAccess(project string) (ProjectAccess, bool) {
+	for _, access := range a.settings.Projects {
+		if access.Project == project {
+			return access, true
+		}
+
+		// "**" at the end of a pattern matches any number of path segments
+		if strings.HasSuffix(access.Project, "**") && strings.HasPrefix(project, strings.TrimSuffix(access.Project, "**")) {
+			return access, true
+		}
+
+		matched, err := path.Match(access.Project, project)
+		if err == nil && matched {
+			return access, true
+		}
+	}
+
+	return ProjectAccess{}, false
+}
+
+func (i *Identity) matches(principals []string) bool {
+	if i == nil {
+		return false
+	}
+
+	for _, p := range principals {
+		if p == i.Name {
+			return true
+		}
+		for _, g := range i.Groups {
+			if p == g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (i *Identity) name() string {
+	if i == nil {
+		return "anonymous"
+	}
+	return i.Name
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func testJWT(secret string, claims string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(claims))
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(header + "." + payload))
+	return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func bearer(token string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+var _ = Describe("authenticator", func() {
+	settings, err := LoadAuthSettings(strings.NewReader(`
+anonymous_read: true
+tokens:
+  - name: team-a-ci
+    token: team-a-secret
+    groups: [team-a]
+  - name: ops
+    token: ops-secret
+    admin: true
+jwt:
+  secret: jwt-secret
+  issuer: idp
+projects:
+  - project: private
+    read: [team-a]
+    write: [team-a]
+  - project: team-a/**
+    write: [team-a]
+`))
+
+	It("should load settings", func() {
+		Expect(err).To(BeNil())
+	})
+
+	auth, _ := newAuthenticator(&Settings{Auth: settings, AdminToken: "legacy-admin"})
+
+	It("should treat callers without tokens as anonymous", func() {
+		identity, err := auth.authenticate(bearer(""))
+		Expect(err).To(BeNil())
+		Expect(identity).To(BeNil())
+	})
+
+	It("should reject unknown tokens", func() {
+		_, err := auth.authenticate(bearer("nope"))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should accept static tokens", func() {
+		identity, err := auth.authenticate(bearer("team-a-secret"))
+		Expect(err).To(BeNil())
+		Expect(identity.Name).To(Equal("team-a-ci"))
+		Expect(identity.Groups).To(Equal([]string{"team-a"}))
+	})
+
+	It("should accept the admin token", func() {
+		identity, err := auth.authenticate(bearer("legacy-admin"))
+		Expect(err).To(BeNil())
+		Expect(identity.Admin).To(BeTrue())
+	})
+
+	It("should accept signed jwts", func() {
+		token := testJWT("jwt-secret", `{"sub":"jane","iss":"idp","groups":["team-a"]}`)
+
+		identity, err := auth.authenticate(bearer(token))
+		Expect(err).To(BeNil())
+		Expect(identity.Name).To(Equal("jane"))
+		Expect(identity.Groups).To(Equal([]string{"team-a"}))
+	})
+
+	It("should reject jwts with a bad signature", func() {
+		token := testJWT("wrong-secret", `{"sub":"jane","iss":"idp"}`)
+
+		_, err := auth.authenticate(bearer(token))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should reject expired jwts", func() {
+		token := testJWT("jwt-secret", fmt.Sprintf(`{"sub":"jane","iss":"idp","exp":%d}`, time.Now().Add(-time.Minute).Unix()))
+
+		_, err := auth.authenticate(bearer(token))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should only let owners write", func() {
+		teamA := &Identity{Name: "team-a-ci", Groups: []string{"team-a"}}
+		other := &Identity{Name: "someone"}
+
+		Expect(auth.authorize(teamA, "team-a/service", writePermission)).To(BeTrue())
+		Expect(auth.authorize(other, "team-a/service", writePermission)).To(BeFalse())
+		Expect(auth.authorize(nil, "team-a/service", writePermission)).To(BeFalse())
+		Expect(auth.authorize(teamA, "unlisted", writePermission)).To(BeFalse())
+		Expect(auth.authorize(&Identity{Name: "ops", Admin: true}, "unlisted", writePermission)).To(BeTrue())
+	})
+
+	It("should restrict reads of private projects", func() {
+		teamA := &Identity{Name: "team-a-ci", Groups: []string{"team-a"}}
+
+		Expect(auth.authorize(nil, "team-a/service", readPermission)).To(BeTrue())
+		Expect(auth.authorize(nil, "private", readPermission)).To(BeFalse())
+		Expect(auth.authorize(&Identity{Name: "someone"}, "private", readPermission)).To(BeFalse())
+		Expect(auth.authorize(teamA, "private", readPermission)).To(BeTrue())
+	})
+
+	It("should allow everything without auth settings", func() {
+		open, _ := newAuthenticator(&Settings{})
+
+		Expect(open.authorize(nil, "anything", writePermission)).To(BeTrue())
+	})
+})
@@ -0,0 +1,346 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// archives are stored once per digest and versions refer to them from their metadata
+const blobRoot = "/blobs/sha256"
+
+var sha256Digest = regexp.MustCompile(`^sha256:([0-9a-f]{64})$`)
+
+func blobPath(digest string) (string, bool) {
+	match := sha256Digest.FindStringSubmatch(digest)
+	if match == nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s/%s.tar.gz", blobRoot, match[1]), true
+}
+
+// blobLocks serializes changes to which versions refer to a blob, so a blob found by a
+// push is not removed before the push's metadata refers to it. Servers sharing storage
+// are not covered.
+type blobLocks struct {
+	mutex sync.Mutex
+	locks map[string]*blobLock
+}
+
+type blobLock struct {
+	sync.Mutex
+	waiting int
+}
+
+func newBlobLocks() *blobLocks {
+	return &blobLocks{locks: map[string]*blobLock{}}
+}
+
+// lock holds the digest until the returned func is called
+func (l *blobLocks) lock(digest string) func() {
+	l.mutex.Lock()
+	lock, ok := l.locks[digest]
+	if !ok {
+		lock = &blobLock{}
+		l.locks[digest] = lock
+	}
+	lock.waiting++
+	l.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		l.mutex.Lock()
+		lock.waiting--
+		if lock.waiting == 0 {
+			delete(l.locks, digest)
+		}
+		l.mutex.Unlock()
+	}
+}
+
+// storeBlob stores an archive unless an identical one already exists, callers hold the
+// digest's lock until the metadata referring to it is written
+func (r *projectRouter) storeBlob(digest string, archive io.Reader) error {
+	pth, ok := blobPath(digest)
+	if !ok {
+		return errors.New(fmt.Sprintf("invalid digest '%s'", digest))
+	}
+
+	if r.storage.Exists(pth) {
+		return nil
+	}
+
+	err := r.storage.MkDir(blobRoot)
+	if err != nil {
+		return err
+	}
+
+	return r.storage.CreateFile(pth, archive)
+}
+
+// archivePath finds where the archive of a published version is stored, versions
+// published before archives were shared keep it in their own folder
+func (r *projectRouter) archivePath(info versionInfo, versionPath string) (string, bool) {
+	if pth, ok := blobPath(info.Digest); ok && r.storage.Exists(pth) {
+		return pth, true
+	}
+
+	pth := versionPath + "/data.tar.gz"
+	return pth, r.storage.Exists(pth)
+}
+
+// findArchive reads a version's metadata and finds its archive
+func (r *projectRouter) findArchive(project string, idlType string, version string) (versionInfo, string, bool, error) {
+	typePath := fmt.Sprintf("/projects/%s/%s", project, idlType)
+
+	info, err := r.readMetadata(typePath, version)
+	if err != nil {
+		return info, "", false, err
+	}
+
+	pth, ok := r.archivePath(info, fmt.Sprintf("%s/%s", typePath, version))
+	return info, pth, ok, nil
+}
+
+// blobRef is a version whose metadata refers to a blob
+type blobRef struct {
+	Project string `json:"project"`
+	Type    string `json:"type"`
+	Version string `json:"version"`
+}
+
+func (b blobRef) path() string {
+	return fmt.Sprintf("/projects/%s/%s/%s", b.Project, b.Type, b.Version)
+}
+
+// blobRefsPath is where the versions referring to a blob are recorded next to it
+func blobRefsPath(digest string) (string, bool) {
+	match := sha256Digest.FindStringSubmatch(digest)
+	if match == nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s/%s.refs", blobRoot, match[1]), true
+}
+
+// blobRefs reads the versions referring to a blob, callers hold the digest's lock.
+// Blobs stored before references were recorded get their record from one walk over
+// every version.
+func (r *projectRouter) blobRefs(digest string) ([]blobRef, error) {
+	pth, ok := blobRefsPath(digest)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("invalid digest '%s'", digest))
+	}
+
+	if !r.storage.Exists(pth) {
+		blob, _ := blobPath(digest)
+		if !r.storage.Exists(blob) {
+			return []blobRef{}, nil
+		}
+
+		refs, err := r.scanBlobRefs(digest)
+		if err != nil {
+			return nil, err
+		}
+		return refs, r.writeBlobRefs(digest, refs)
+	}
+
+	f, err := r.storage.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	refs := []blobRef{}
+	err = json.NewDecoder(f).Decode(&refs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode references to archive '%s'", digest)
+	}
+	return refs, nil
+}
+
+func (r *projectRouter) writeBlobRefs(digest string, refs []blobRef) error {
+	pth, ok := blobRefsPath(digest)
+	if !ok {
+		return errors.New(fmt.Sprintf("invalid digest '%s'", digest))
+	}
+
+	b, err := json.Marshal(refs)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode archive references")
+	}
+
+	err = r.storage.MkDir(blobRoot)
+	if err != nil {
+		return err
+	}
+
+	err = r.storage.CreateFile(pth, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "failed to write references to archive '%s'", digest)
+	}
+	return nil
+}
+
+// scanBlobRefs finds the versions referring to a blob by reading all metadata
+func (r *projectRouter) scanBlobRefs(digest string) ([]blobRef, error) {
+	refs := []blobRef{}
+
+	projects, err := r.storage.ListFolders("/projects")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, project := range projects {
+		types, err := r.storage.ListFolders(fmt.Sprintf("/projects/%s", project))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, idlType := range types {
+			typePath := fmt.Sprintf("/projects/%s/%s", project, idlType)
+			versions, err := r.storage.ListFolders(typePath)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, version := range versions {
+				info, err := r.readMetadata(typePath, version)
+				if err != nil {
+					return nil, err
+				}
+				if info.Digest == digest {
+					refs = append(refs, blobRef{project, idlType, version})
+				}
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// addBlobRef records that a version refers to a blob, callers hold the digest's lock
+func (r *projectRouter) addBlobRef(digest string, ref blobRef) error {
+	refs, err := r.blobRefs(digest)
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range refs {
+		if existing == ref {
+			return nil
+		}
+	}
+
+	return r.writeBlobRefs(digest, append(refs, ref))
+}
+
+// releaseBlob forgets that a version referred to a blob and deletes the archive once
+// no version refers to it anymore
+func (r *projectRouter) releaseBlob(digest string, ref blobRef) error {
+	pth, ok := blobPath(digest)
+	if !ok {
+		return nil
+	}
+
+	unlock := r.blobs.lock(digest)
+	defer unlock()
+
+	refs, err := r.blobRefs(digest)
+	if err != nil {
+		return err
+	}
+
+	remaining := []blobRef{}
+	for _, existing := range refs {
+		if existing != ref {
+			remaining = append(remaining, existing)
+		}
+	}
+
+	if len(remaining) > 0 {
+		return r.writeBlobRefs(digest, remaining)
+	}
+
+	err = r.storage.Remove(pth)
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete archive '%s'", digest)
+	}
+
+	refsPath, _ := blobRefsPath(digest)
+	if r.storage.Exists(refsPath) {
+		err = r.storage.Remove(refsPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to delete references to archive '%s'", digest)
+		}
+	}
+	return nil
+}
+
+// pullBlob serves an archive by its digest to callers that can read a project using it
+func (r *projectRouter) pullBlob(ctx HttpContext) (*DataResponse, error) {
+	digest := ctx.Args["digest"]
+	pth, ok := blobPath(digest)
+	if !ok {
+		return &DataResponse{
+			StatusCode: 400,
+			Error:      fmt.Sprintf("'%s' is not a sha256 digest", digest),
+		}, nil
+	}
+
+	missing := &DataResponse{
+		StatusCode: 404,
+		Error:      fmt.Sprintf("archive '%s' does not exist", digest),
+	}
+	if !r.storage.Exists(pth) {
+		return missing, nil
+	}
+
+	// without auth settings everyone reads everything
+	private := false
+	if r.settings.Auth != nil {
+		unlock := r.blobs.lock(digest)
+		refs, err := r.blobRefs(digest)
+		unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		readable := false
+		for _, ref := range refs {
+			if !r.readable(nil, ref.Project) {
+				private = true
+			}
+			if r.readable(ctx.Identity, ref.Project) {
+				readable = true
+			}
+		}
+		if !readable {
+			return missing, nil
+		}
+	}
+
+	f, err := r.storage.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataResponse{
+		StatusCode: 200,
+		Data:       f,
+		ETag:       digest,
+		Immutable:  true,
+		Private:    private,
+	}, nil
+}
+
+// readable tells if a caller may read a project, as the router decides for a project's routes
+func (r *projectRouter) readable(identity *Identity, project string) bool {
+	return (&authenticator{settings: r.settings.Auth}).authorize(identity, project, readPermission)
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("blobLocks", func() {
+	It("should hold a digest until it is unlocked", func() {
+		locks := newBlobLocks()
+		unlock := locks.lock("sha256:a")
+
+		var locked int32
+		go func() {
+			defer locks.lock("sha256:a")()
+			atomic.StoreInt32(&locked, 1)
+		}()
+
+		// other digests are not held up
+		locks.lock("sha256:b")()
+
+		Consistently(func() int32 { return atomic.LoadInt32(&locked) }, 50*time.Millisecond).Should(Equal(int32(0)))
+		unlock()
+		Eventually(func() int32 { return atomic.LoadInt32(&locked) }).Should(Equal(int32(1)))
+
+		Eventually(func() int {
+			locks.mutex.Lock()
+			defer locks.mutex.Unlock()
+			return len(locks.locks)
+		}).Should(Equal(0))
+	})
+})
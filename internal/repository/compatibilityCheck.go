@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/pkg/errors"
+	"github.com/syncromatics/idl-repository/internal/compatibility"
+)
+
+type incompatibleVersion struct {
+	Message string                `json:"message"`
+	Mode    compatibility.Mode    `json:"mode"`
+	Against string                `json:"against"`
+	Issues  []compatibility.Issue `json:"issues"`
+}
+
+func (v incompatibleVersion) Error() string {
+	return v.Message
+}
+
+func (v incompatibleVersion) errorCode() string {
+	return "incompatible_schema"
+}
+
+func (v incompatibleVersion) errorDetails() interface{} {
+	return struct {
+		Mode    compatibility.Mode    `json:"mode"`
+		Against string                `json:"against"`
+		Issues  []compatibility.Issue `json:"issues"`
+	}{v.Mode, v.Against, v.Issues}
+}
+
+// previousVersion finds the highest published version below version, which is what a push is compared with
+func (r *projectRouter) previousVersion(project string, idlType string, version string) (string, bool, error) {
+	pth := fmt.Sprintf("/projects/%s/%s", project, idlType)
+	folders, err := r.storage.ListFolders(pth)
+	if err != nil {
+		return "", false, err
+	}
+
+	current, err := semver.NewVersion(version)
+	if err != nil {
+		current = nil
+	}
+
+	var previous *semver.Version
+	for _, folder := range folders {
+		v, err := semver.NewVersion(folder)
+		if err != nil {
+			continue
+		}
+		if current != nil && !v.LessThan(*current) {
+			continue
+		}
+		if previous != nil && !previous.LessThan(*v) {
+			continue
+		}
+		if info, _, ok, err := r.findArchive(project, idlType, folder); err != nil || !ok || info.Yanked {
+			continue
+		}
+		previous = v
+	}
+
+	if previous == nil {
+		return "", false, nil
+	}
+	return previous.String(), true, nil
+}
+
+// checkCompatibility compares the uploaded archive with the previous version, a nil response means it is compatible
+func (r *projectRouter) checkCompatibility(mode compatibility.Mode, project string, idlType string, previous string, upload io.ReadSeeker) (*JsonResponse, error) {
+	_, pth, _, err := r.findArchive(project, idlType, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := r.storage.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	before, err := compatibility.ReadArchive(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read version '%s'", previous)
+	}
+
+	after, err := compatibility.ReadArchive(upload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read upload")
+	}
+
+	_, err = upload.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to rewind upload")
+	}
+
+	issues := compatibility.Check(mode, idlType, before, after)
+	if len(issues) == 0 {
+		return nil, nil
+	}
+
+	return &JsonResponse{
+		StatusCode: 409,
+		Model: incompatibleVersion{
+			Message: fmt.Sprintf("changes are not %s compatible with version '%s'", mode, previous),
+			Mode:    mode,
+			Against: previous,
+			Issues:  issues,
+		},
+	}, nil
+}
+
+// spool buffers an upload on disk so it can be read more than once, closing it removes the file
+func spool(reader io.Reader) (*spooledFile, error) {
+	f, err := ioutil.TempFile("", "idl-upload-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary file")
+	}
+
+	spooled := &spooledFile{f}
+	_, err = io.Copy(f, reader)
+	if err != nil {
+		spooled.Close()
+		return nil, err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		spooled.Close()
+		return nil, errors.Wrap(err, "failed to rewind temporary file")
+	}
+
+	return spooled, nil
+}
+
+type spooledFile struct {
+	*os.File
+}
+
+func (f *spooledFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// deprecation marks a project, type or version that should no longer be used.
+// Scope is where the marker was set, versions inherit the markers of their type and project.
+type deprecation struct {
+	Scope       string `json:"scope,omitempty"`
+	Message     string `json:"message"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// deprecationTarget is the folder a deprecation is stored in and what it applies to
+func deprecationTarget(ctx HttpContext) (string, string, string) {
+	project, idlType, version := ctx.Args["project"], ctx.Args["type"], ctx.Args["version"]
+
+	switch {
+	case version != "":
+		return fmt.Sprintf("/projects/%s/%s/%s", project, idlType, version), "version",
+			fmt.Sprintf("project '%s' with type '%s' does not have version '%s'", project, idlType, version)
+	case idlType != "":
+		return fmt.Sprintf("/projects/%s/%s", project, idlType), "type",
+			fmt.Sprintf("project '%s' does not have type '%s'", project, idlType)
+	}
+	return fmt.Sprintf("/projects/%s", project), "project",
+		fmt.Sprintf("project '%s' does not exist", project)
+}
+
+// getDeprecation answers the marker that applies at a level, null when there is none.
+// Archives are cached for good so clients ask here whether they were deprecated since.
+func (r *projectRouter) getDeprecation(ctx HttpContext) (*JsonResponse, error) {
+	pth, scope, missing := deprecationTarget(ctx)
+	if !r.storage.Exists(pth) {
+		return &JsonResponse{
+			StatusCode: 404,
+			Model:      missing,
+		}, nil
+	}
+
+	project, idlType, version := ctx.Args["project"], ctx.Args["type"], ctx.Args["version"]
+
+	var marker *deprecation
+	var err error
+	switch scope {
+	case "version":
+		marker, err = r.deprecationOf(project, idlType, version)
+	case "type":
+		marker, err = r.deprecations(project, idlType)
+	default:
+		marker, err = r.readDeprecation(pth)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &JsonResponse{
+		StatusCode: 200,
+		Model:      marker,
+	}, nil
+}
+
+func (r *projectRouter) deprecate(ctx HttpContext) (*JsonResponse, error) {
+	pth, scope, missing := deprecationTarget(ctx)
+	if !r.storage.Exists(pth) {
+		return &JsonResponse{
+			StatusCode: 404,
+			Model:      missing,
+		}, nil
+	}
+
+	marker := deprecation{}
+	err := json.NewDecoder(ctx.Body).Decode(&marker)
+	if err != nil && err != io.EOF {
+		return &JsonResponse{
+			StatusCode: 400,
+			Model:      fmt.Sprintf("invalid deprecation: %s", err),
+		}, nil
+	}
+	if marker.Message == "" {
+		return &JsonResponse{
+			StatusCode: 400,
+			Model:      "a deprecation needs a message",
+		}, nil
+	}
+	marker.Scope = scope
+
+	b, err := json.Marshal(marker)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode deprecation")
+	}
+
+	err = r.storage.CreateFile(pth+"/deprecated.json", bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write deprecation")
+	}
+
+	r.audit(ctx, auditEntry{
+		Action:  "deprecate",
+		Project: ctx.Args["project"],
+		Type:    ctx.Args["type"],
+		Version: ctx.Args["version"],
+	})
+
+	return &JsonResponse{
+		StatusCode: 200,
+		Model:      marker,
+	}, nil
+}
+
+func (r *projectRouter) undeprecate(ctx HttpContext) (*JsonResponse, error) {
+	pth, _, missing := deprecationTarget(ctx)
+	if !r.storage.Exists(pth) {
+		return &JsonResponse{
+			StatusCode: 404,
+			Model:      missing,
+		}, nil
+	}
+
+	err := r.storage.Remove(pth + "/deprecated.json")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to remove deprecation")
+	}
+
+	r.audit(ctx, auditEntry{
+		Action:  "undeprecate",
+		Project: ctx.Args["project"],
+		Type:    ctx.Args["type"],
+		Version: ctx.Args["version"],
+	})
+
+	return &JsonResponse{
+		StatusCode: 200,
+	}, nil
+}
+
+// readDeprecation reads the marker stored in a folder, nil when there is none
+func (r *projectRouter) readDeprecation(pth string) (*deprecation, error) {
+	pth += "/deprecated.json"
+	if !r.storage.Exists(pth) {
+		return nil, nil
+	}
+
+	f, err := r.storage.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	marker := &deprecation{}
+	err = json.NewDecoder(f).Decode(marker)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode deprecation '%s'", pth)
+	}
+	return marker, nil
+}
+
+// deprecations reads the markers of a project and one of its types, which every version inherits
+func (r *projectRouter) deprecations(project string, idlType string) (*deprecation, error) {
+	marker, err := r.readDeprecation(fmt.Sprintf("/projects/%s/%s", project, idlType))
+	if marker != nil || err != nil {
+		return marker, err
+	}
+	return r.readDeprecation(fmt.Sprintf("/projects/%s", project))
+}
+
+// versionDeprecation is the most specific marker that applies to a version
+func (r *projectRouter) versionDeprecation(project string, idlType string, version string, inherited *deprecation) (*deprecation, error) {
+	marker, err := r.readDeprecation(fmt.Sprintf("/projects/%s/%s/%s", project, idlType, version))
+	if marker != nil || err != nil {
+		return marker, err
+	}
+	return inherited, nil
+}
+
+func (r *projectRouter) deprecationOf(project string, idlType string, version string) (*deprecation, error) {
+	inherited, err := r.deprecations(project, idlType)
+	if err != nil {
+		return nil, err
+	}
+	return r.versionDeprecation(project, idlType, version, inherited)
+}
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/idl-repository/internal/compatibility"
+	goversion "go.hein.dev/go-version"
+)
+
+// readyPath is rewritten by every readiness check to prove storage takes writes
+const readyPath = "/health/ready"
+
+type healthRouter struct {
+	storage  Storage
+	settings *Settings
+}
+
+type serverInfo struct {
+	Version       string   `json:"version"`
+	Commit        string   `json:"commit"`
+	Date          string   `json:"date"`
+	Compatibility string   `json:"compatibility"`
+	Features      []string `json:"features"`
+}
+
+func newHealthRouter(storage Storage, settings *Settings) *healthRouter {
+	return &healthRouter{storage, settings}
+}
+
+func (h *healthRouter) Register(router Muxer) {
+	router.RegisterJson(http.MethodGet, "/v1/info", h.info)
+}
+
+// healthz only tells that the process is able to answer
+func (h *healthRouter) healthz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+// readyz answers 503 until the storage can be written and read back
+func (h *healthRouter) readyz(w http.ResponseWriter, req *http.Request) {
+	setRequestID(w, req)
+
+	// storage that stalls is not ready, and nobody waits once the probe gave up
+	checked := make(chan error, 1)
+	go func() {
+		checked <- h.checkStorage()
+	}()
+
+	var err error
+	select {
+	case err = <-checked:
+	case <-req.Context().Done():
+		err = errors.Wrap(req.Context().Err(), "storage did not answer before the probe gave up")
+	}
+	if err != nil {
+		h.settings.logger().WithField("request_id", w.Header().Get(requestIDHeader)).WithError(err).Warn("not ready")
+		writeError(w, http.StatusServiceUnavailable, "storage is not available")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+func (h *healthRouter) checkStorage() error {
+	err := h.storage.MkDir("/health")
+	if err != nil {
+		return err
+	}
+
+	err = h.storage.CreateFile(readyPath, strings.NewReader(time.Now().UTC().Format(time.RFC3339Nano)))
+	if err != nil {
+		return errors.Wrap(err, "failed to write to storage")
+	}
+
+	f, err := h.storage.ReadFile(readyPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to read from storage")
+	}
+	defer f.Close()
+
+	_, err = ioutil.ReadAll(f)
+	if err != nil {
+		return errors.Wrap(err, "failed to read from storage")
+	}
+	return nil
+}
+
+func (h *healthRouter) info(ctx HttpContext) (*JsonResponse, error) {
+	build := h.settings.Build
+	if build == nil {
+		build = goversion.New("dev", "none", "unknown")
+	}
+
+	mode := h.settings.DefaultCompatibility
+	if mode == "" {
+		mode = compatibility.None
+	}
+
+	return &JsonResponse{
+		StatusCode: 200,
+		Model: serverInfo{
+			Version:       build.Version,
+			Commit:        build.Commit,
+			Date:          build.Date,
+			Compatibility: string(mode),
+			Features:      h.settings.features(),
+		},
+	}, nil
+}
+
+// features names the optional parts of the server that are turned on
+func (s *Settings) features() []string {
+	features := []string{}
+	if s.Auth != nil {
+		features = append(features, "authentication")
+	}
+	if s.AdminToken != "" {
+		features = append(features, "admin-token")
+	}
+	if s.TLSCert != "" {
+		features = append(features, "tls")
+	}
+	if s.TLSClientCA != "" {
+		features = append(features, "client-certificates")
+	}
+	if s.MaxBodySize > 0 || s.MaxArchiveSize > 0 || s.MaxFileSize > 0 {
+		features = append(features, "upload-limits")
+	}
+	return append(features, "audit", "compatibility-checks", "metrics")
+}
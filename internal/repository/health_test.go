@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/idl-repository/internal/compatibility"
+	goversion "go.hein.dev/go-version"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// readOnlyStorage fails every write like a full disk or a bucket without write access
+type readOnlyStorage struct {
+	Storage
+}
+
+func (s *readOnlyStorage) CreateFile(path string, file io.Reader) error {
+	return errors.New("read-only file system")
+}
+
+// stalledStorage never finishes a write like a bucket that stopped answering
+type stalledStorage struct {
+	Storage
+	release chan struct{}
+}
+
+func (s *stalledStorage) CreateFile(path string, file io.Reader) error {
+	<-s.release
+	return nil
+}
+
+var _ = Describe("healthRouter", func() {
+	var (
+		router *projectRouter
+		dir    string
+	)
+
+	BeforeEach(func() {
+		router, dir = testRouter()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should be ready once storage can be written", func() {
+		health := newHealthRouter(router.storage, &Settings{})
+
+		w := httptest.NewRecorder()
+		health.healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		Expect(w.Code).To(Equal(200))
+
+		w = httptest.NewRecorder()
+		health.readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		Expect(w.Code).To(Equal(200))
+
+		health = newHealthRouter(&readOnlyStorage{router.storage}, &Settings{})
+
+		w = httptest.NewRecorder()
+		health.readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		Expect(w.Code).To(Equal(503))
+		Expect(decodeError(w).Code).To(Equal("unavailable"))
+	})
+
+	It("should not be ready when storage stalls past the probe's deadline", func() {
+		release := make(chan struct{})
+		defer close(release)
+		health := newHealthRouter(&stalledStorage{router.storage, release}, &Settings{})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		w := httptest.NewRecorder()
+		health.readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil).WithContext(ctx))
+		Expect(w.Code).To(Equal(503))
+	})
+
+	It("should answer probes on the metrics port", func() {
+		handler := adminHandler(newMetrics(), newHealthRouter(router.storage, &Settings{}))
+
+		for _, path := range []string{"/healthz", "/readyz", "/metrics"} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			Expect(w.Code).To(Equal(200), path)
+		}
+	})
+
+	It("should describe the build and enabled features", func() {
+		health := newHealthRouter(router.storage, &Settings{
+			AdminToken:           "secret",
+			DefaultCompatibility: compatibility.Backward,
+			Build:                goversion.New("1.4.0", "abc123", "2019-07-01"),
+		})
+
+		response, err := health.info(HttpContext{})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(200))
+
+		b, err := json.Marshal(response.Model)
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(MatchJSON(`{
+			"version": "1.4.0",
+			"commit": "abc123",
+			"date": "2019-07-01",
+			"compatibility": "backward",
+			"features": ["admin-token", "audit", "compatibility-checks", "metrics"]
+		}`))
+	})
+})
@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// jwtVerifier checks HS256 tokens signed with a shared secret or RS256 tokens signed
+// with the private half of a configured public key
+type jwtVerifier struct {
+	settings  *JWTSettings
+	secret    []byte
+	publicKey *rsa.PublicKey
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+}
+
+func newJWTVerifier(settings *JWTSettings) (*jwtVerifier, error) {
+	v := &jwtVerifier{settings: settings}
+
+	if settings.Secret != "" {
+		v.secret = []byte(settings.Secret)
+	}
+
+	if settings.PublicKeyFile != "" {
+		b, err := ioutil.ReadFile(settings.PublicKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read jwt public key")
+		}
+
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, errors.New("jwt public key is not PEM encoded")
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse jwt public key")
+		}
+
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("jwt public key must be an RSA key")
+		}
+		v.publicKey = rsaKey
+	}
+
+	if v.secret == nil && v.publicKey == nil {
+		return nil, errors.New("jwt settings require a secret or a public key file")
+	}
+
+	return v, nil
+}
+
+func (v *jwtVerifier) verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+
+	header := jwtHeader{}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed jwt header")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed jwt signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Algorithm == "HS256" && v.secret != nil:
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, errors.New("invalid jwt signature")
+		}
+
+	case header.Algorithm == "RS256" && v.publicKey != nil:
+		hash := sha256.Sum256(signed)
+		err = rsa.VerifyPKCS1v15(v.publicKey, crypto.SHA256, hash[:], signature)
+		if err != nil {
+			return nil, errors.New("invalid jwt signature")
+		}
+
+	default:
+		return nil, errors.Errorf("unsupported jwt algorithm '%s'", header.Algorithm)
+	}
+
+	claims := map[string]interface{}{}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed jwt claims")
+	}
+
+	return v.identity(claims, time.Now())
+}
+
+func (v *jwtVerifier) identity(claims map[string]interface{}, now time.Time) (*Identity, error) {
+	if exp, ok := claims["exp"].(float64); ok && now.Unix() >= int64(exp) {
+		return nil, errors.New("jwt has expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Unix() < int64(nbf) {
+		return nil, errors.New("jwt is not valid yet")
+	}
+
+	if v.settings.Issuer != "" && claims["iss"] != v.settings.Issuer {
+		return nil, errors.New("jwt has the wrong issuer")
+	}
+
+	if v.settings.Audience != "" && !contains(stringsClaim(claims["aud"]), v.settings.Audience) {
+		return nil, errors.New("jwt has the wrong audience")
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("jwt has no subject")
+	}
+
+	groupsClaim := v.settings.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	groups := stringsClaim(claims[groupsClaim])
+
+	return &Identity{
+		Name:   subject,
+		Groups: groups,
+		Admin:  v.settings.AdminGroup != "" && contains(groups, v.settings.AdminGroup),
+	}, nil
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, value)
+}
+
+// stringsClaim reads claims that may be a single string or a list of strings
+func stringsClaim(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []interface{}:
+		values := []string{}
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// NewLogger writes one line per entry to stderr, either as logfmt or as json
+func NewLogger(format string) (*logrus.Logger, error) {
+	logger := logrus.New()
+	logger.Out = os.Stderr
+
+	switch format {
+	case "logfmt":
+		logger.Formatter = &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}
+	case "json":
+		logger.Formatter = &logrus.JSONFormatter{}
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown log format '%s', use logfmt or json", format))
+	}
+
+	return logger, nil
+}
+
+// logger falls back to the standard logger for settings made without one
+func (s *Settings) logger() logrus.FieldLogger {
+	if s.Log == nil {
+		return logrus.StandardLogger()
+	}
+	return s.Log
+}
+
+type contextKey int
+
+const requestLogKey contextKey = iota
+
+// requestLog collects what handlers learn about a request for its log line
+type requestLog struct {
+	identity string
+}
+
+func (r *routerWrapper) identify(req *http.Request, identity *Identity) {
+	if entry, ok := req.Context().Value(requestLogKey).(*requestLog); ok {
+		entry.identity = identity.name()
+	}
+}
+
+// loggingWriter remembers the status and size of a response
+type loggingWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *loggingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// logRequests names each request and logs it once it is answered
+func (r *routerWrapper) logRequests(path string, next http.Handler) http.Handler {
+	route := routePatterns.ReplaceAllString(path, "{$1}")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		requestID := setRequestID(w, req)
+
+		entry := &requestLog{}
+		logged := &loggingWriter{ResponseWriter: w}
+		next.ServeHTTP(logged, req.WithContext(context.WithValue(req.Context(), requestLogKey, entry)))
+
+		fields := logrus.Fields{
+			"request_id": requestID,
+			"method":     req.Method,
+			"path":       req.URL.Path,
+			"status":     logged.status,
+			"bytes":      logged.written,
+			"duration":   time.Since(start).Seconds(),
+			"remote":     req.RemoteAddr,
+		}
+		if route != "" {
+			fields["route"] = route
+		}
+		if entry.identity != "" {
+			fields["identity"] = entry.identity
+		}
+
+		r.log.WithFields(fields).Info("request")
+	})
+}
+
+// internalError logs what went wrong under the request id and only tells the client the id
+func (r *routerWrapper) internalError(w http.ResponseWriter, err error) {
+	r.failed(w, err)
+	writeError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// failed logs an error of a request that can no longer be answered with an error
+func (r *routerWrapper) failed(w http.ResponseWriter, err error) {
+	r.log.WithField("request_id", w.Header().Get(requestIDHeader)).WithError(err).Error("request failed")
+}
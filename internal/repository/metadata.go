@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/pkg/errors"
+)
+
+// versionInfo describes a published version, versions published before metadata
+// was recorded only have a version. Yanked versions are left out of listings but
+// are still served to those that pinned them.
+type versionInfo struct {
+	Version    string     `json:"version"`
+	Published  *time.Time `json:"published,omitempty"`
+	Size       int64      `json:"size,omitempty"`
+	Digest     string     `json:"digest,omitempty"`
+	Publisher  string     `json:"publisher,omitempty"`
+	Yanked     bool       `json:"yanked,omitempty"`
+	YankReason string     `json:"yank_reason,omitempty"`
+	// Deprecated is filled in when responding and never stored with the metadata
+	Deprecated *deprecation `json:"deprecated,omitempty"`
+}
+
+// measuringReader counts and hashes an upload as it is stored
+type measuringReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	size   int64
+}
+
+func newMeasuringReader(reader io.Reader) *measuringReader {
+	return &measuringReader{reader: reader, hash: sha256.New()}
+}
+
+func (m *measuringReader) Read(p []byte) (int, error) {
+	n, err := m.reader.Read(p)
+	m.size += int64(n)
+	m.hash.Write(p[:n])
+	return n, err
+}
+
+func (m *measuringReader) digest() string {
+	return fmt.Sprintf("sha256:%x", m.hash.Sum(nil))
+}
+
+func (r *projectRouter) writeMetadata(pth string, info versionInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode metadata")
+	}
+
+	err = r.storage.CreateFile(pth+"/metadata.json", bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "failed to write metadata")
+	}
+	return nil
+}
+
+func (r *projectRouter) readMetadata(pth string, version string) (versionInfo, error) {
+	info := versionInfo{Version: version}
+
+	pth = fmt.Sprintf("%s/%s/metadata.json", pth, version)
+	if !r.storage.Exists(pth) {
+		return info, nil
+	}
+
+	f, err := r.storage.ReadFile(pth)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+
+	err = json.NewDecoder(f).Decode(&info)
+	if err != nil {
+		return info, errors.Wrapf(err, "failed to decode metadata of version '%s'", version)
+	}
+	info.Version = version
+
+	return info, nil
+}
+
+// sortVersions orders versions by semantic version, anything that is not a
+// semantic version comes last in name order
+func sortVersions(versions []versionInfo) {
+	parsed := map[string]*semver.Version{}
+	for _, v := range versions {
+		s, err := semver.NewVersion(v.Version)
+		if err == nil {
+			parsed[v.Version] = s
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		a, aOk := parsed[versions[i].Version]
+		b, bOk := parsed[versions[j].Version]
+		switch {
+		case aOk && bOk:
+			return a.LessThan(*b)
+		case aOk != bOk:
+			return aOk
+		}
+		return versions[i].Version < versions[j].Version
+	})
+}
+
+// latestVersion picks the highest semantic version from sorted versions, names that
+// are not versions are listed but never the latest
+func latestVersion(versions []versionInfo) []versionInfo {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if _, err := semver.NewVersion(versions[i].Version); err == nil {
+			return versions[i : i+1]
+		}
+	}
+	return []versionInfo{}
+}
+
+func isPrerelease(version string) bool {
+	v, err := semver.NewVersion(version)
+	return err == nil && v.PreRelease != ""
+}
@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// routePatterns are left out of route labels so they read like the api documentation
+var routePatterns = regexp.MustCompile(`\{([^:}]+):[^}]*\}`)
+
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests   *prometheus.CounterVec
+	durations  *prometheus.HistogramVec
+	inFlight   *prometheus.GaugeVec
+	uploaded   *prometheus.CounterVec
+	downloaded *prometheus.CounterVec
+	storage    *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "idl_repository_requests_total",
+			Help: "Requests handled by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "idl_repository_request_duration_seconds",
+			Help:    "How long requests took by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "idl_repository_requests_in_flight",
+			Help: "Requests being handled by route.",
+		}, []string{"route"}),
+		uploaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "idl_repository_uploaded_bytes_total",
+			Help: "Request body bytes of successful requests by project and type.",
+		}, []string{"project", "type"}),
+		downloaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "idl_repository_downloaded_bytes_total",
+			Help: "Archive bytes of successful downloads by project and type.",
+		}, []string{"project", "type"}),
+		storage: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "idl_repository_storage_operation_duration_seconds",
+			Help:    "How long storage operations took by operation and result.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"operation", "result"}),
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.requests,
+		m.durations,
+		m.inFlight,
+		m.uploaded,
+		m.downloaded,
+		m.storage,
+	)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument counts and times requests to a route registered with path
+func (m *metrics) instrument(path string, next http.Handler) http.Handler {
+	route := routePatterns.ReplaceAllString(path, "{$1}")
+	labels := prometheus.Labels{"route": route}
+
+	return promhttp.InstrumentHandlerInFlight(m.inFlight.With(labels),
+		promhttp.InstrumentHandlerDuration(m.durations.MustCurryWith(labels),
+			promhttp.InstrumentHandlerCounter(m.requests.MustCurryWith(labels), next)))
+}
+
+// countUpload adds the request body bytes read by a successful handler to its project and type,
+// failed requests are left out so made up project and type names never become labels
+func (m *metrics) countUpload(req *http.Request, bytes int64) {
+	vars := mux.Vars(req)
+	if bytes > 0 && vars["project"] != "" && vars["type"] != "" {
+		m.uploaded.WithLabelValues(vars["project"], vars["type"]).Add(float64(bytes))
+	}
+}
+
+// countingWriter counts the response body bytes sent for a project and type
+type countingWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// countDownload adds the bytes sent by a successful handler to its project and type
+func (m *metrics) countDownload(req *http.Request, w *countingWriter) {
+	vars := mux.Vars(req)
+	if w.written > 0 && vars["project"] != "" && vars["type"] != "" {
+		m.downloaded.WithLabelValues(vars["project"], vars["type"]).Add(float64(w.written))
+	}
+}
+
+// instrumentedStorage times every operation of the storage it wraps
+type instrumentedStorage struct {
+	storage Storage
+	metrics *metrics
+}
+
+func instrumentStorage(storage Storage, m *metrics) Storage {
+	return &instrumentedStorage{storage, m}
+}
+
+func (s *instrumentedStorage) observe(operation string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	s.metrics.storage.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
+
+func (s *instrumentedStorage) ListFolders(path string) ([]string, error) {
+	start := time.Now()
+	folders, err := s.storage.ListFolders(path)
+	s.observe("list_folders", start, err)
+	return folders, err
+}
+
+func (s *instrumentedStorage) ListFiles(path string) ([]string, error) {
+	start := time.Now()
+	files, err := s.storage.ListFiles(path)
+	s.observe("list_files", start, err)
+	return files, err
+}
+
+func (s *instrumentedStorage) File(path string) (io.Reader, error) {
+	start := time.Now()
+	f, err := s.storage.File(path)
+	s.observe("file", start, err)
+	return f, err
+}
+
+func (s *instrumentedStorage) Exists(path string) bool {
+	start := time.Now()
+	exists := s.storage.Exists(path)
+	s.observe("exists", start, nil)
+	return exists
+}
+
+func (s *instrumentedStorage) MkDir(path string) error {
+	start := time.Now()
+	err := s.storage.MkDir(path)
+	s.observe("mkdir", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) CreateFile(path string, file io.Reader) error {
+	start := time.Now()
+	err := s.storage.CreateFile(path, file)
+	s.observe("create_file", start, err)
+	return err
+}
+
+// ReadFile is timed until the file is open, reading it is part of the request it serves
+func (s *instrumentedStorage) ReadFile(path string) (io.ReadCloser, error) {
+	start := time.Now()
+	f, err := s.storage.ReadFile(path)
+	s.observe("read_file", start, err)
+	return f, err
+}
+
+func (s *instrumentedStorage) Remove(path string) error {
+	start := time.Now()
+	err := s.storage.Remove(path)
+	s.observe("remove", start, err)
+	return err
+}
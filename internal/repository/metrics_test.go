@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("metrics", func() {
+	var (
+		router  *projectRouter
+		dir     string
+		m       *metrics
+		handler *mux.Router
+	)
+
+	BeforeEach(func() {
+		router, dir = testRouter()
+		m = newMetrics()
+		router.storage = instrumentStorage(router.storage, m)
+
+		auth, err := newAuthenticator(&Settings{})
+		Expect(err).To(BeNil())
+		handler = mux.NewRouter()
+		router.Register(newRouterWrapper(handler, auth, &Settings{}, m))
+		handler.Handle("/metrics", m.handler())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	serve := func(method string, path string, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(body)))
+		return w
+	}
+
+	It("should count requests, bytes and storage operations", func() {
+		upload := testArchive(map[string]string{"a.proto": "contents"})
+		Expect(serve(http.MethodPost, "/v1/projects/common/types/proto/versions/1.0.0", upload).Code).To(Equal(201))
+
+		download := serve(http.MethodGet, "/v1/projects/common/types/proto/versions/1.0.0/data.tar.gz", nil)
+		Expect(download.Code).To(Equal(200))
+		Expect(serve(http.MethodGet, "/v1/projects/common/types/proto/versions/2.0.0/data.tar.gz", nil).Code).To(Equal(404))
+		Expect(serve(http.MethodPost, "/v1/projects/made-up/types/proto/versions/1.0.0", []byte("not an archive")).Code).To(Equal(400))
+		Expect(serve(http.MethodGet, "/v1/projects/made-up/types/other/versions/1.0.0/data.tar.gz", nil).Code).To(Equal(404))
+
+		b, err := ioutil.ReadAll(serve(http.MethodGet, "/metrics", nil).Body)
+		Expect(err).To(BeNil())
+		exposed := string(b)
+
+		Expect(exposed).To(ContainSubstring(`idl_repository_requests_total{code="201",method="post",route="/v1/projects/{project}/types/{type}/versions/{version}"} 1`))
+		Expect(exposed).To(ContainSubstring(`idl_repository_requests_total{code="404",method="get",route="/v1/projects/{project}/types/{type}/versions/{version}/data.tar.gz"} 2`))
+		Expect(exposed).To(ContainSubstring(`idl_repository_request_duration_seconds_count{method="get",route="/v1/projects/{project}/types/{type}/versions/{version}/data.tar.gz"} 3`))
+		Expect(exposed).To(ContainSubstring(`idl_repository_requests_in_flight{route="/v1/projects"} 0`))
+		Expect(exposed).To(ContainSubstring(`idl_repository_uploaded_bytes_total{project="common",type="proto"} ` + strconv.Itoa(len(upload))))
+		Expect(exposed).To(ContainSubstring(`idl_repository_downloaded_bytes_total{project="common",type="proto"} ` + strconv.Itoa(download.Body.Len())))
+		Expect(exposed).To(ContainSubstring(`idl_repository_storage_operation_duration_seconds_count{operation="create_file",result="ok"}`))
+		Expect(exposed).ToNot(ContainSubstring(`project="made-up"`))
+	})
+})
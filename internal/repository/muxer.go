@@ -6,80 +6,172 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 type routerWrapper struct {
-	router *mux.Router
+	router      *mux.Router
+	auth        *authenticator
+	maxBodySize int64
+	metrics     *metrics
+	log         logrus.FieldLogger
 }
 
-func newRouterWrapper(router *mux.Router) *routerWrapper {
-	return &routerWrapper{router}
+func newRouterWrapper(router *mux.Router, auth *authenticator, settings *Settings, metrics *metrics) *routerWrapper {
+	return &routerWrapper{router, auth, settings.MaxBodySize, metrics, settings.logger()}
 }
 
-func (r *routerWrapper) RegisterJson(path string, handler func(HttpContext) (*JsonResponse, error)) {
-	r.router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+// wrap names, logs and measures every request to a route
+func (r *routerWrapper) wrap(path string, handler http.HandlerFunc) http.Handler {
+	return r.metrics.instrument(path, r.logRequests(path, handler))
+}
+
+func (r *routerWrapper) RegisterJson(method string, path string, handler func(HttpContext) (*JsonResponse, error)) {
+	r.router.Methods(method).Path(path).Handler(r.wrap(path, func(w http.ResponseWriter, req *http.Request) {
+		requestID := w.Header().Get(requestIDHeader)
+
+		identity, ok := r.authorize(w, req)
+		if !ok {
+			return
+		}
+		if identity != nil {
+			r.identify(req, identity)
+		}
+
+		body, ok := r.limitBody(w, req)
+		if !ok {
+			return
+		}
+
 		context := HttpContext{
-			Args: mux.Vars(r),
-			Body: r.Body,
+			Args:      mux.Vars(req),
+			Query:     req.URL.Query(),
+			Header:    req.Header,
+			Body:      body,
+			Identity:  identity,
+			RequestID: requestID,
 		}
 
 		response, err := handler(context)
+		if body.exceeded {
+			r.bodyTooLarge(w)
+			return
+		}
 		if err != nil {
-			fmt.Println(err)
-			w.WriteHeader(500)
+			r.internalError(w, err)
 			return
 		}
 
+		if response.StatusCode >= 400 {
+			writeError(w, response.StatusCode, response.Model)
+			return
+		}
+		r.metrics.countUpload(req, body.read)
+
 		b, err := json.Marshal(response.Model)
 		if err != nil {
-			fmt.Println(err)
-			w.WriteHeader(500)
+			r.internalError(w, err)
 			return
 		}
 
 		w.Header().Add("Content-Type", "application/json")
 		w.WriteHeader(response.StatusCode)
 		w.Write(b)
-	})
+	}))
 }
 
-func (r *routerWrapper) RegisterData(path string, handler func(HttpContext) (*DataResponse, error)) {
-	r.router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+func (r *routerWrapper) RegisterData(method string, path string, handler func(HttpContext) (*DataResponse, error)) {
+	methods := []string{method}
+	if method == http.MethodGet {
+		methods = append(methods, http.MethodHead)
+	}
+
+	r.router.Methods(methods...).Path(path).Handler(r.wrap(path, func(w http.ResponseWriter, req *http.Request) {
+		requestID := w.Header().Get(requestIDHeader)
+
+		identity, ok := r.authorize(w, req)
+		if !ok {
+			return
+		}
+		if identity != nil {
+			r.identify(req, identity)
+		}
+
+		body, ok := r.limitBody(w, req)
+		if !ok {
+			return
+		}
+
 		context := HttpContext{
-			Args: mux.Vars(r),
-			Body: r.Body,
+			Args:      mux.Vars(req),
+			Query:     req.URL.Query(),
+			Header:    req.Header,
+			Body:      body,
+			Identity:  identity,
+			RequestID: requestID,
 		}
 
 		response, err := handler(context)
+		if body.exceeded {
+			r.bodyTooLarge(w)
+			return
+		}
 		if err != nil {
-			fmt.Println(err)
-			w.WriteHeader(500)
+			r.internalError(w, err)
 			return
 		}
 
 		if response.StatusCode != http.StatusOK {
-			fmt.Println(response.Error)
-			w.WriteHeader(response.StatusCode)
+			writeError(w, response.StatusCode, response.Error)
 			return
 		}
+		r.metrics.countUpload(req, body.read)
 		defer response.Data.Close()
 
+		counted := &countingWriter{ResponseWriter: w}
+		defer r.metrics.countDownload(req, counted)
+		w = counted
+
 		w.Header().Add("Content-Type", "application/octet-stream")
+		if response.ETag != "" {
+			w.Header().Set("ETag", fmt.Sprintf("\"%s\"", response.ETag))
+		}
+		if response.Immutable {
+			w.Header().Set("Cache-Control", r.cacheControl(req, response.Private))
+		}
+
+		// ServeContent answers conditional and range requests, storage that cannot
+		// seek only gets conditional requests answered
+		if content, ok := response.Data.(io.ReadSeeker); ok {
+			http.ServeContent(w, req, "", response.Modified, content)
+			return
+		}
+
+		if !response.Modified.IsZero() {
+			w.Header().Set("Last-Modified", response.Modified.UTC().Format(http.TimeFormat))
+		}
+		if notModified(req, response.ETag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
 		w.WriteHeader(response.StatusCode)
 
 		wb := bufio.NewWriter(w)
 		rb := bufio.NewReader(response.Data)
 		defer wb.Flush()
 
+		// the status is already sent so failures part way can only be logged
 		buf := make([]byte, 1024)
 		for {
 			// read a chunk
 			n, err := rb.Read(buf)
 			if err != nil && err != io.EOF {
-				fmt.Println(err)
-				w.WriteHeader(500)
+				r.failed(w, err)
 				return
 			}
 			if n == 0 {
@@ -88,10 +180,117 @@ func (r *routerWrapper) RegisterData(path string, handler func(HttpContext) (*Da
 
 			// write a chunk
 			if _, err := w.Write(buf[:n]); err != nil {
-				fmt.Println(err)
-				w.WriteHeader(500)
+				r.failed(w, err)
 				return
 			}
 		}
-	})
+	}))
+}
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// limitedBody fails reads once more than the allowed bytes were sent, handlers
+// see a read error and the wrapper answers 413 whatever the handler made of it
+type limitedBody struct {
+	body      io.Reader
+	remaining int64
+	limited   bool
+	exceeded  bool
+	read      int64
+}
+
+func (b *limitedBody) Read(p []byte) (n int, err error) {
+	defer func() { b.read += int64(n) }()
+
+	if !b.limited {
+		return b.body.Read(p)
+	}
+	if b.exceeded {
+		return 0, errBodyTooLarge
+	}
+
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err = b.body.Read(p)
+	if int64(n) > b.remaining {
+		b.exceeded = true
+		n = int(b.remaining)
+		b.remaining = 0
+		return n, errBodyTooLarge
+	}
+
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// limitBody rejects requests announcing a body over the limit before reading any of it
+func (r *routerWrapper) limitBody(w http.ResponseWriter, req *http.Request) (*limitedBody, bool) {
+	body := &limitedBody{body: req.Body, remaining: r.maxBodySize, limited: r.maxBodySize > 0}
+	if body.limited && req.ContentLength > r.maxBodySize {
+		r.bodyTooLarge(w)
+		return nil, false
+	}
+	return body, true
+}
+
+func (r *routerWrapper) bodyTooLarge(w http.ResponseWriter) {
+	// the rest of the body is not read so the connection cannot be reused
+	w.Header().Set("Connection", "close")
+	writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than the limit of %d bytes", r.maxBodySize))
+}
+
+// cacheControl lets shared caches keep data anyone may read, data that needs
+// authentication is only kept by the client
+func (r *routerWrapper) cacheControl(req *http.Request, private bool) string {
+	visibility := "private"
+	if !private && r.auth.authorize(nil, mux.Vars(req)["project"], readPermission) {
+		visibility = "public"
+	}
+	return fmt.Sprintf("%s, max-age=31536000, immutable", visibility)
+}
+
+func notModified(req *http.Request, etag string) bool {
+	if etag == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(req.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == fmt.Sprintf("\"%s\"", etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks the caller may use the route, reads need read access to the
+// project and everything else needs write access
+func (r *routerWrapper) authorize(w http.ResponseWriter, req *http.Request) (*Identity, bool) {
+	identity, err := r.auth.authenticate(req)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return nil, false
+	}
+
+	needs := writePermission
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		needs = readPermission
+	}
+
+	project := mux.Vars(req)["project"]
+	if r.auth.authorize(identity, project, needs) {
+		return identity, true
+	}
+
+	if identity == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "authentication required")
+		return nil, false
+	}
+
+	writeError(w, http.StatusForbidden, fmt.Sprintf("'%s' does not have access to project '%s'", identity.Name, project))
+	return nil, false
 }
@@ -1,32 +1,89 @@
 package repository
 
 import (
-	"errors"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/idl-repository/internal/compatibility"
 )
 
 type projectRouter struct {
-	storage Storage
+	storage  Storage
+	settings *Settings
+	blobs    *blobLocks
 }
 
-func newProjectRouter(storage Storage) *projectRouter {
-	return &projectRouter{storage}
+func newProjectRouter(storage Storage, settings *Settings) *projectRouter {
+	return &projectRouter{storage, settings, newBlobLocks()}
 }
 
 func (r *projectRouter) Register(router Muxer) {
-	router.RegisterJson("/v1/projects", r.listHandler)
-	router.RegisterJson("/v1/projects/{project:.*}/types", r.listTypeHandler)
-	router.RegisterJson("/v1/projects/{project:.*}/types/{type:.*}/versions", r.listVersionHandler)
-	router.RegisterData("/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}/data.tar.gz", r.pullVersion)
-	router.RegisterJson("/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}", r.submitVersion)
+	router.RegisterJson(http.MethodGet, "/v1/audit", r.listAudit)
+	router.RegisterData(http.MethodGet, "/v1/blobs/{digest}", r.pullBlob)
+	router.RegisterJson(http.MethodGet, "/v1/projects", r.listHandler)
+	router.RegisterJson(http.MethodGet, "/v1/projects/{project:.*}/settings", r.getSettings)
+	router.RegisterJson(http.MethodPut, "/v1/projects/{project:.*}/settings", r.putSettings)
+	router.RegisterJson(http.MethodGet, "/v1/projects/{project:.*}/types", r.listTypeHandler)
+	router.RegisterJson(http.MethodGet, "/v1/projects/{project:.*}/types/{type:.*}/versions", r.listVersionHandler)
+	router.RegisterData(http.MethodGet, "/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}/data.tar.gz", r.pullVersion)
+	router.RegisterJson(http.MethodGet, "/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}/dependencies", r.listDependencies)
+	router.RegisterJson(http.MethodPost, "/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}/dependencies", r.submitDependencies)
+	router.RegisterJson(http.MethodPut, "/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}/yank", r.yankVersion)
+	router.RegisterJson(http.MethodDelete, "/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}/yank", r.unyankVersion)
+	router.RegisterJson(http.MethodGet, "/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}/deprecation", r.getDeprecation)
+	router.RegisterJson(http.MethodPut, "/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}/deprecation", r.deprecate)
+	router.RegisterJson(http.MethodDelete, "/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}/deprecation", r.undeprecate)
+	router.RegisterJson(http.MethodGet, "/v1/projects/{project:.*}/types/{type:.*}/deprecation", r.getDeprecation)
+	router.RegisterJson(http.MethodPut, "/v1/projects/{project:.*}/types/{type:.*}/deprecation", r.deprecate)
+	router.RegisterJson(http.MethodDelete, "/v1/projects/{project:.*}/types/{type:.*}/deprecation", r.undeprecate)
+	router.RegisterJson(http.MethodGet, "/v1/projects/{project:.*}/deprecation", r.getDeprecation)
+	router.RegisterJson(http.MethodPut, "/v1/projects/{project:.*}/deprecation", r.deprecate)
+	router.RegisterJson(http.MethodDelete, "/v1/projects/{project:.*}/deprecation", r.undeprecate)
+	router.RegisterJson(http.MethodGet, "/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}", r.getVersion)
+	router.RegisterJson(http.MethodDelete, "/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}", r.deleteVersion)
+	router.RegisterJson(http.MethodPost, "/v1/projects/{project:.*}/types/{type:.*}/versions/{version:.*}", r.submitVersion)
+}
+
+// projectInfo and typeInfo are listed with the deprecation that applies to them when asked
+// for with ?deprecations=true, listings are plain names otherwise so older clients keep working
+type projectInfo struct {
+	Name       string       `json:"name"`
+	Deprecated *deprecation `json:"deprecated,omitempty"`
+}
+
+type typeInfo struct {
+	Name       string       `json:"name"`
+	Deprecated *deprecation `json:"deprecated,omitempty"`
 }
 
 func (r *projectRouter) listHandler(ctx HttpContext) (*JsonResponse, error) {
-	projects, err := r.storage.ListFolders("/projects")
+	names, err := r.storage.ListFolders("/projects")
 	if err != nil {
 		return nil, err
 	}
 
+	if ctx.Query.Get("deprecations") != "true" {
+		return &JsonResponse{
+			StatusCode: 200,
+			Model:      names,
+		}, nil
+	}
+
+	projects := []projectInfo{}
+	for _, name := range names {
+		marker, err := r.readDeprecation(fmt.Sprintf("/projects/%s", name))
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, projectInfo{Name: name, Deprecated: marker})
+	}
+
 	return &JsonResponse{
 		StatusCode: 200,
 		Model:      projects,
@@ -49,11 +106,27 @@ func (r *projectRouter) listTypeHandler(ctx HttpContext) (*JsonResponse, error)
 		}, nil
 	}
 
-	types, err := r.storage.ListFolders(pth)
+	names, err := r.storage.ListFolders(pth)
 	if err != nil {
 		return nil, err
 	}
 
+	if ctx.Query.Get("deprecations") != "true" {
+		return &JsonResponse{
+			StatusCode: 200,
+			Model:      names,
+		}, nil
+	}
+
+	types := []typeInfo{}
+	for _, name := range names {
+		marker, err := r.deprecations(project, name)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, typeInfo{Name: name, Deprecated: marker})
+	}
+
 	return &JsonResponse{
 		StatusCode: 200,
 		Model:      types,
@@ -91,11 +164,50 @@ func (r *projectRouter) listVersionHandler(ctx HttpContext) (*JsonResponse, erro
 		}, nil
 	}
 
-	versions, err := r.storage.ListFolders(pth)
+	folders, err := r.storage.ListFolders(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	prerelease := ctx.Query.Get("prerelease") != "false"
+	yanked := ctx.Query.Get("yanked") == "true"
+
+	inherited, err := r.deprecations(project, idlType)
 	if err != nil {
 		return nil, err
 	}
 
+	// a folder without an archive is left behind by an upload that failed
+	versions := []versionInfo{}
+	for _, version := range folders {
+		if !prerelease && isPrerelease(version) {
+			continue
+		}
+
+		info, err := r.readMetadata(pth, version)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := r.archivePath(info, fmt.Sprintf("%s/%s", pth, version)); !ok {
+			continue
+		}
+		if info.Yanked && !yanked {
+			continue
+		}
+
+		info.Deprecated, err = r.versionDeprecation(project, idlType, version, inherited)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, info)
+	}
+
+	sortVersions(versions)
+
+	if ctx.Query.Get("latest") == "true" {
+		versions = latestVersion(versions)
+	}
+
 	return &JsonResponse{
 		StatusCode: 200,
 		Model:      versions,
@@ -118,24 +230,164 @@ func (r *projectRouter) submitVersion(ctx HttpContext) (*JsonResponse, error) {
 		return nil, errors.New("failed to get version from args")
 	}
 
-	pth := fmt.Sprintf("/projects/%s/%s/%s", project, idlType, version)
+	replaced, _, exists, err := r.findArchive(project, idlType, version)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		response, err := r.overwrite(ctx, project, idlType, version)
+		if response != nil || err != nil {
+			return response, err
+		}
+	}
+
+	// older clients leave the header out and post dependencies once the archive is in
+	var dependencies []dependency
+	if value := ctx.Header.Get(dependenciesHeader); value != "" {
+		var invalid *JsonResponse
+		dependencies, invalid = decodeDependencies(strings.NewReader(value))
+		if invalid != nil {
+			return invalid, nil
+		}
+	}
+
+	settings, err := r.projectSettings(project)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, found, err := r.previousVersion(project, idlType, version)
+	if err != nil {
+		return nil, err
+	}
 
-	err := r.storage.MkDir(pth)
+	// the digest decides where the archive goes so it is only known once the upload is in
+	measured := newMeasuringReader(ctx.Body)
+	upload, err := spool(newArchiveValidator(measured, r.archiveLimits()))
+	if invalid, ok := isInvalidArchive(err); ok {
+		return &JsonResponse{
+			StatusCode: 400,
+			Model:      invalid,
+		}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
+	defer upload.Close()
+
+	mode := settings.modeFor(idlType)
+	if mode != compatibility.None && found {
+		response, err := r.checkCompatibility(mode, project, idlType, previous, upload)
+		if response != nil || err != nil {
+			return response, err
+		}
+	}
+
+	published := time.Now().UTC()
+	info := versionInfo{
+		Version:   version,
+		Published: &published,
+		Size:      measured.size,
+		Digest:    measured.digest(),
+		Publisher: ctx.Identity.name(),
+	}
 
-	pth = fmt.Sprintf("%s/data.tar.gz", pth)
+	ref := blobRef{project, idlType, version}
 
-	err = r.storage.CreateFile(pth, ctx.Body)
+	// no delete may remove the blob between finding it and the metadata referring to it
+	unlock := r.blobs.lock(info.Digest)
+	err = r.publish(ref, info, upload, dependencies, exists)
+	unlock()
 	if err != nil {
 		return nil, err
 	}
+
+	// an overwrite leaves the old archive behind unless another version still uses it
+	if exists && replaced.Digest != info.Digest {
+		err = r.releaseBlob(replaced.Digest, ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	action := "push"
+	if exists {
+		action = "overwrite"
+	}
+	r.audit(ctx, auditEntry{
+		Action:  action,
+		Project: project,
+		Type:    idlType,
+		Version: version,
+		Digest:  info.Digest,
+	})
+
 	return &JsonResponse{
 		StatusCode: 201,
 	}, nil
 }
 
+// publish stores the archive and the dependencies of a version, then the metadata that makes it visible
+func (r *projectRouter) publish(ref blobRef, info versionInfo, upload io.Reader, dependencies []dependency, exists bool) error {
+	err := r.storeBlob(info.Digest, upload)
+	if err != nil {
+		return err
+	}
+
+	// the reference goes first so the archive is never deleted while metadata points to it
+	err = r.addBlobRef(info.Digest, ref)
+	if err != nil {
+		return err
+	}
+
+	pth := ref.path()
+	err = r.storage.MkDir(pth)
+	if err != nil {
+		return err
+	}
+
+	// overwrites without dependencies drop the old list
+	switch {
+	case dependencies != nil:
+		err = r.writeDependencies(pth, dependencies)
+	case exists && r.storage.Exists(pth+"/dependencies.json"):
+		err = r.storage.Remove(pth + "/dependencies.json")
+	}
+	if err != nil {
+		return err
+	}
+
+	return r.writeMetadata(pth, info)
+}
+
+func (r *projectRouter) getVersion(ctx HttpContext) (*JsonResponse, error) {
+	project, idlType, version, err := versionArgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info, _, ok, err := r.findArchive(project, idlType, version)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &JsonResponse{
+			StatusCode: 404,
+			Model:      fmt.Sprintf("project '%s' with type '%s' does not have version '%s'", project, idlType, version),
+		}, nil
+	}
+
+	info.Deprecated, err = r.deprecationOf(project, idlType, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JsonResponse{
+		StatusCode: 200,
+		Model:      info,
+	}, nil
+}
+
 func (r *projectRouter) pullVersion(ctx HttpContext) (*DataResponse, error) {
 	project, ok := ctx.Args["project"]
 	if !ok {
@@ -172,9 +424,10 @@ func (r *projectRouter) pullVersion(ctx HttpContext) (*DataResponse, error) {
 		}, nil
 	}
 
-	pth = fmt.Sprintf("/projects/%s/%s/%s", project, idlType, version)
-
-	ok = r.storage.Exists(pth)
+	info, pth, ok, err := r.findArchive(project, idlType, version)
+	if err != nil {
+		return nil, err
+	}
 	if !ok {
 		return &DataResponse{
 			StatusCode: 404,
@@ -182,13 +435,192 @@ func (r *projectRouter) pullVersion(ctx HttpContext) (*DataResponse, error) {
 		}, nil
 	}
 
-	f, err := r.storage.ReadFile(pth + "/data.tar.gz")
+	f, err := r.storage.ReadFile(pth)
 	if err != nil {
 		return nil, err
 	}
 
-	return &DataResponse{
+	// published versions only change when an admin overwrites them
+	response := &DataResponse{
 		StatusCode: 200,
 		Data:       f,
+		ETag:       info.Digest,
+		Immutable:  true,
+	}
+	if info.Published != nil {
+		response.Modified = *info.Published
+	}
+	return response, nil
+}
+
+// dependenciesHeader lets clients send the dependencies of a version with its archive
+const dependenciesHeader = "X-Idl-Dependencies"
+
+type dependency struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Type       string `json:"type"`
+	Repository string `json:"repository"`
+}
+
+func (r *projectRouter) listDependencies(ctx HttpContext) (*JsonResponse, error) {
+	project, idlType, version, err := versionArgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pth := fmt.Sprintf("/projects/%s/%s/%s", project, idlType, version)
+
+	ok := r.storage.Exists(pth)
+	if !ok {
+		return &JsonResponse{
+			StatusCode: 404,
+			Model:      fmt.Sprintf("project '%s' with type '%s' does not have version '%s'", project, idlType, version),
+		}, nil
+	}
+
+	dependencies := []dependency{}
+
+	pth = fmt.Sprintf("%s/dependencies.json", pth)
+	if !r.storage.Exists(pth) {
+		return &JsonResponse{
+			StatusCode: 200,
+			Model:      dependencies,
+		}, nil
+	}
+
+	f, err := r.storage.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = json.NewDecoder(f).Decode(&dependencies)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode dependencies")
+	}
+
+	return &JsonResponse{
+		StatusCode: 200,
+		Model:      dependencies,
 	}, nil
 }
+
+func (r *projectRouter) submitDependencies(ctx HttpContext) (*JsonResponse, error) {
+	project, idlType, version, err := versionArgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pth := fmt.Sprintf("/projects/%s/%s/%s", project, idlType, version)
+
+	ok := r.storage.Exists(pth)
+	if !ok {
+		return &JsonResponse{
+			StatusCode: 404,
+			Model:      fmt.Sprintf("project '%s' with type '%s' does not have version '%s'", project, idlType, version),
+		}, nil
+	}
+
+	exists := r.storage.Exists(pth + "/dependencies.json")
+	if exists {
+		response, err := r.overwrite(ctx, project, idlType, version)
+		if response != nil || err != nil {
+			return response, err
+		}
+	}
+
+	dependencies, invalid := decodeDependencies(ctx.Body)
+	if invalid != nil {
+		return invalid, nil
+	}
+
+	err = r.writeDependencies(pth, dependencies)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		r.audit(ctx, auditEntry{
+			Action:  "overwrite-dependencies",
+			Project: project,
+			Type:    idlType,
+			Version: version,
+		})
+	}
+
+	return &JsonResponse{
+		StatusCode: 201,
+	}, nil
+}
+
+// decodeDependencies reads a json list of dependencies, a non nil response describes why it is invalid
+func decodeDependencies(reader io.Reader) ([]dependency, *JsonResponse) {
+	dependencies := []dependency{}
+	err := json.NewDecoder(reader).Decode(&dependencies)
+	if err != nil {
+		return nil, &JsonResponse{
+			StatusCode: 400,
+			Model:      fmt.Sprintf("invalid dependencies: %s", err),
+		}
+	}
+
+	for _, d := range dependencies {
+		if d.Name == "" || d.Type == "" || d.Version == "" {
+			return nil, &JsonResponse{
+				StatusCode: 400,
+				Model:      "dependencies require a name, type and version",
+			}
+		}
+	}
+
+	return dependencies, nil
+}
+
+func (r *projectRouter) writeDependencies(pth string, dependencies []dependency) error {
+	b, err := json.Marshal(dependencies)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode dependencies")
+	}
+
+	return r.storage.CreateFile(fmt.Sprintf("%s/dependencies.json", pth), bytes.NewReader(b))
+}
+
+// overwrite decides if an already published version may be replaced, only admins can
+// force it. A nil response means go ahead.
+func (r *projectRouter) overwrite(ctx HttpContext, project string, idlType string, version string) (*JsonResponse, error) {
+	if ctx.Query.Get("force") != "true" {
+		return &JsonResponse{
+			StatusCode: 409,
+			Model:      fmt.Sprintf("project '%s' with type '%s' already has version '%s', published versions cannot be changed", project, idlType, version),
+		}, nil
+	}
+
+	if ctx.Identity == nil || !ctx.Identity.Admin {
+		return &JsonResponse{
+			StatusCode: 403,
+			Model:      "only admins can overwrite published versions",
+		}, nil
+	}
+
+	return nil, nil
+}
+
+func versionArgs(ctx HttpContext) (string, string, string, error) {
+	project, ok := ctx.Args["project"]
+	if !ok {
+		return "", "", "", errors.New("failed to get project from args")
+	}
+
+	idlType, ok := ctx.Args["type"]
+	if !ok {
+		return "", "", "", errors.New("failed to get type from args")
+	}
+
+	version, ok := ctx.Args["version"]
+	if !ok {
+		return "", "", "", errors.New("failed to get version from args")
+	}
+
+	return project, idlType, version, nil
+}
@@ -0,0 +1,745 @@
+package repository
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/syncromatics/idl-repository/internal/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func testRouter() (*projectRouter, string) {
+	dir, err := ioutil.TempDir("", "idl-repository")
+	Expect(err).To(BeNil())
+
+	s, err := storage.NewFileStorage(dir, nil)
+	Expect(err).To(BeNil())
+
+	return newProjectRouter(s, &Settings{}), dir
+}
+
+// unseekableStorage hides that files can seek, like storage that streams them
+type unseekableStorage struct {
+	Storage
+}
+
+func (s unseekableStorage) ReadFile(pth string) (io.ReadCloser, error) {
+	f, err := s.Storage.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{f, f}, nil
+}
+
+// walkingStorage counts how often all projects are listed
+type walkingStorage struct {
+	Storage
+	walks int
+}
+
+func (s *walkingStorage) ListFolders(pth string) ([]string, error) {
+	if pth == "/projects" {
+		s.walks++
+	}
+	return s.Storage.ListFolders(pth)
+}
+
+// auditFailingStorage cannot write the audit log but stores everything else
+type auditFailingStorage struct {
+	Storage
+}
+
+func (s auditFailingStorage) CreateFile(pth string, file io.Reader) error {
+	if strings.HasPrefix(pth, auditRoot+"/") {
+		return errors.New("audit log is full")
+	}
+	return s.Storage.CreateFile(pth, file)
+}
+
+// blobFiles lists the stored archives, leaving out the records of who refers to them
+func blobFiles(dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "blobs", "sha256", "*.tar.gz"))
+	Expect(err).To(BeNil())
+
+	names := []string{}
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	return names
+}
+
+func push(r *projectRouter, idlType string, version string, files map[string]string) *JsonResponse {
+	response, err := r.submitVersion(HttpContext{
+		Args:     map[string]string{"project": "common", "type": idlType, "version": version},
+		Query:    url.Values{},
+		Body:     ioutil.NopCloser(bytes.NewReader(testArchive(files))),
+		Identity: &Identity{Name: "ci"},
+	})
+	Expect(err).To(BeNil())
+	return response
+}
+
+func listVersions(r *projectRouter, query string) []versionInfo {
+	values, _ := url.ParseQuery(query)
+	response, err := r.listVersionHandler(HttpContext{
+		Args:  map[string]string{"project": "common", "type": "proto"},
+		Query: values,
+	})
+	Expect(err).To(BeNil())
+	Expect(response.StatusCode).To(Equal(200))
+	return response.Model.([]versionInfo)
+}
+
+func decodeError(w *httptest.ResponseRecorder) apiError {
+	e := apiError{}
+	Expect(w.Header().Get("Content-Type")).To(Equal("application/json"))
+	Expect(json.Unmarshal(w.Body.Bytes(), &e)).To(Succeed())
+	return e
+}
+
+func versionNames(versions []versionInfo) []string {
+	names := []string{}
+	for _, v := range versions {
+		names = append(names, v.Version)
+	}
+	return names
+}
+
+var _ = Describe("projectRouter", func() {
+	var (
+		router *projectRouter
+		dir    string
+	)
+
+	BeforeEach(func() {
+		router, dir = testRouter()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should list versions sorted by semantic version with metadata", func() {
+		for _, version := range []string{"1.10.0", "1.2.0", "2.0.0-beta.1", "1.9.3"} {
+			Expect(push(router, "proto", version, map[string]string{"a.proto": version}).StatusCode).To(Equal(201))
+		}
+
+		versions := listVersions(router, "")
+		Expect(versionNames(versions)).To(Equal([]string{"1.2.0", "1.9.3", "1.10.0", "2.0.0-beta.1"}))
+
+		Expect(versions[0].Published).ToNot(BeNil())
+		Expect(versions[0].Size).To(Equal(int64(len(testArchive(map[string]string{"a.proto": "1.2.0"})))))
+		Expect(versions[0].Digest).To(HavePrefix("sha256:"))
+		Expect(versions[0].Publisher).To(Equal("ci"))
+	})
+
+	It("should filter pre-releases and pick the latest", func() {
+		for _, version := range []string{"1.0.0", "1.1.0", "2.0.0-rc.1"} {
+			push(router, "proto", version, map[string]string{"a.proto": version})
+		}
+
+		Expect(versionNames(listVersions(router, "prerelease=false"))).To(Equal([]string{"1.0.0", "1.1.0"}))
+		Expect(versionNames(listVersions(router, "latest=true"))).To(Equal([]string{"2.0.0-rc.1"}))
+		Expect(versionNames(listVersions(router, "latest=true&prerelease=false"))).To(Equal([]string{"1.1.0"}))
+	})
+
+	It("should only pick semantic versions as the latest", func() {
+		for _, version := range []string{"1.0.0", "1.1.0", "2.0.0-rc.1", "tmp"} {
+			Expect(push(router, "proto", version, map[string]string{"a.proto": version}).StatusCode).To(Equal(201))
+		}
+
+		Expect(versionNames(listVersions(router, ""))).To(Equal([]string{"1.0.0", "1.1.0", "2.0.0-rc.1", "tmp"}))
+		Expect(versionNames(listVersions(router, "latest=true"))).To(Equal([]string{"2.0.0-rc.1"}))
+		Expect(versionNames(listVersions(router, "latest=true&prerelease=false"))).To(Equal([]string{"1.1.0"}))
+	})
+
+	It("should reject incompatible versions under the project's mode", func() {
+		response, err := router.putSettings(HttpContext{
+			Args: map[string]string{"project": "common"},
+			Body: ioutil.NopCloser(bytes.NewBufferString(`{"compatibility": "none", "types": {"proto": "backward"}}`)),
+		})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(200))
+
+		Expect(push(router, "proto", "1.0.0", map[string]string{"a.proto": "message A { string a = 1; }"}).StatusCode).To(Equal(201))
+
+		response = push(router, "proto", "1.1.0", map[string]string{"a.proto": "message A { int32 a = 1; }"})
+		Expect(response.StatusCode).To(Equal(409))
+		Expect(response.Model.(incompatibleVersion).Against).To(Equal("1.0.0"))
+		Expect(response.Model.(incompatibleVersion).Issues).To(HaveLen(1))
+
+		Expect(push(router, "proto", "1.1.0", map[string]string{"a.proto": "message A { string a = 1; string b = 2; }"}).StatusCode).To(Equal(201))
+		Expect(versionNames(listVersions(router, ""))).To(Equal([]string{"1.0.0", "1.1.0"}))
+	})
+
+	It("should store identical archives once", func() {
+		files := map[string]string{"a.proto": "message A {}"}
+		Expect(push(router, "proto", "1.0.0", files).StatusCode).To(Equal(201))
+		Expect(push(router, "example", "1.0.0", files).StatusCode).To(Equal(201))
+
+		digest := listVersions(router, "")[0].Digest
+		Expect(blobFiles(dir)).To(Equal([]string{strings.TrimPrefix(digest, "sha256:") + ".tar.gz"}))
+
+		response, err := router.pullVersion(HttpContext{Args: map[string]string{"project": "common", "type": "example", "version": "1.0.0"}})
+		Expect(err).To(BeNil())
+		b, _ := ioutil.ReadAll(response.Data)
+		response.Data.Close()
+		Expect(fmt.Sprintf("sha256:%x", sha256.Sum256(b))).To(Equal(digest))
+	})
+
+	It("should release the archive an overwrite replaces", func() {
+		shared := map[string]string{"a.proto": "shared"}
+		Expect(push(router, "proto", "1.0.0", shared).StatusCode).To(Equal(201))
+		Expect(push(router, "proto", "1.1.0", map[string]string{"a.proto": "own"}).StatusCode).To(Equal(201))
+		Expect(push(router, "example", "1.0.0", shared).StatusCode).To(Equal(201))
+
+		overwrite := func(idlType string, files map[string]string) {
+			response, err := router.submitVersion(HttpContext{
+				Args:     map[string]string{"project": "common", "type": idlType, "version": "1.0.0"},
+				Query:    url.Values{"force": []string{"true"}},
+				Body:     ioutil.NopCloser(bytes.NewReader(testArchive(files))),
+				Identity: &Identity{Name: "admin", Admin: true},
+			})
+			Expect(err).To(BeNil())
+			Expect(response.StatusCode).To(Equal(201))
+		}
+		blobs := func() []string {
+			names := []string{}
+			for _, name := range blobFiles(dir) {
+				names = append(names, "sha256:"+strings.TrimSuffix(name, ".tar.gz"))
+			}
+			return names
+		}
+		digestOf := func(files map[string]string) string {
+			return fmt.Sprintf("sha256:%x", sha256.Sum256(testArchive(files)))
+		}
+
+		// example 1.0.0 still uses the shared archive
+		overwrite("proto", map[string]string{"a.proto": "replaced"})
+		Expect(blobs()).To(ConsistOf(digestOf(shared), digestOf(map[string]string{"a.proto": "own"}), digestOf(map[string]string{"a.proto": "replaced"})))
+
+		overwrite("example", map[string]string{"a.proto": "own"})
+		Expect(blobs()).To(ConsistOf(digestOf(map[string]string{"a.proto": "own"}), digestOf(map[string]string{"a.proto": "replaced"})))
+	})
+
+	It("should record which versions refer to an archive instead of reading every version", func() {
+		files := map[string]string{"a.proto": "shared"}
+		Expect(push(router, "proto", "1.0.0", files).StatusCode).To(Equal(201))
+		Expect(push(router, "example", "1.0.0", files).StatusCode).To(Equal(201))
+		digest := listVersions(router, "")[0].Digest
+
+		refs, err := router.blobRefs(digest)
+		Expect(err).To(BeNil())
+		Expect(refs).To(Equal([]blobRef{{"common", "proto", "1.0.0"}, {"common", "example", "1.0.0"}}))
+
+		walking := &walkingStorage{Storage: router.storage}
+		router.storage = walking
+		router.settings.Auth = &AuthSettings{AnonymousRead: true}
+
+		pulled, err := router.pullBlob(HttpContext{Args: map[string]string{"digest": digest}})
+		Expect(err).To(BeNil())
+		Expect(pulled.StatusCode).To(Equal(200))
+		pulled.Data.Close()
+
+		response, err := router.deleteVersion(HttpContext{
+			Args:     map[string]string{"project": "common", "type": "proto", "version": "1.0.0"},
+			Identity: &Identity{Name: "admin", Admin: true},
+		})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(200))
+
+		Expect(walking.walks).To(Equal(0))
+
+		refs, err = router.blobRefs(digest)
+		Expect(err).To(BeNil())
+		Expect(refs).To(Equal([]blobRef{{"common", "example", "1.0.0"}}))
+	})
+
+	It("should find who refers to archives stored before references were recorded", func() {
+		files := map[string]string{"a.proto": "shared"}
+		Expect(push(router, "proto", "1.0.0", files).StatusCode).To(Equal(201))
+		Expect(push(router, "example", "1.0.0", files).StatusCode).To(Equal(201))
+		digest := listVersions(router, "")[0].Digest
+
+		refsPath, _ := blobRefsPath(digest)
+		Expect(router.storage.Remove(refsPath)).To(Succeed())
+
+		walking := &walkingStorage{Storage: router.storage}
+		router.storage = walking
+
+		for i := 0; i < 2; i++ {
+			refs, err := router.blobRefs(digest)
+			Expect(err).To(BeNil())
+			Expect(refs).To(ConsistOf(blobRef{"common", "proto", "1.0.0"}, blobRef{"common", "example", "1.0.0"}))
+		}
+		Expect(walking.walks).To(Equal(1))
+	})
+
+	It("should serve archives by digest to callers that can read a project using them", func() {
+		router.settings.Auth = &AuthSettings{
+			AnonymousRead: true,
+			Tokens:        []StaticToken{{Name: "team-a-ci", Token: "team-a-secret", Groups: []string{"team-a"}}},
+			Projects:      []ProjectAccess{{Project: "private", Read: []string{"team-a"}, Write: []string{"team-a"}}},
+		}
+
+		public := map[string]string{"a.proto": "public"}
+		private := map[string]string{"a.proto": "private"}
+		Expect(push(router, "proto", "1.0.0", public).StatusCode).To(Equal(201))
+		response, err := router.submitVersion(HttpContext{
+			Args:     map[string]string{"project": "private", "type": "proto", "version": "1.0.0"},
+			Query:    url.Values{},
+			Body:     ioutil.NopCloser(bytes.NewReader(testArchive(private))),
+			Identity: &Identity{Name: "team-a-ci", Groups: []string{"team-a"}},
+		})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(201))
+
+		auth, err := newAuthenticator(router.settings)
+		Expect(err).To(BeNil())
+		handler := mux.NewRouter()
+		router.Register(newRouterWrapper(handler, auth, router.settings, newMetrics()))
+
+		get := func(digest string, token string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/v1/blobs/"+digest, nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w
+		}
+		digestOf := func(files map[string]string) string {
+			return fmt.Sprintf("sha256:%x", sha256.Sum256(testArchive(files)))
+		}
+
+		w := get(digestOf(public), "")
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Body.Bytes()).To(Equal(testArchive(public)))
+		Expect(w.Header().Get("ETag")).To(Equal(`"` + digestOf(public) + `"`))
+		Expect(w.Header().Get("Cache-Control")).To(Equal("public, max-age=31536000, immutable"))
+
+		Expect(get(digestOf(private), "").Code).To(Equal(404))
+
+		w = get(digestOf(private), "team-a-secret")
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Body.Bytes()).To(Equal(testArchive(private)))
+		Expect(w.Header().Get("Cache-Control")).To(Equal("private, max-age=31536000, immutable"))
+
+		Expect(get(digestOf(map[string]string{"a.proto": "missing"}), "").Code).To(Equal(404))
+		Expect(get("sha256:nothex", "").Code).To(Equal(400))
+	})
+
+	It("should serve versions stored before archives were shared", func() {
+		legacy := filepath.Join(dir, "projects", "common", "proto", "0.1.0")
+		Expect(os.MkdirAll(legacy, 0777)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(legacy, "data.tar.gz"), testArchive(map[string]string{"a.proto": ""}), 0666)).To(Succeed())
+
+		Expect(versionNames(listVersions(router, ""))).To(Equal([]string{"0.1.0"}))
+
+		response, err := router.pullVersion(HttpContext{Args: map[string]string{"project": "common", "type": "proto", "version": "0.1.0"}})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(200))
+		response.Data.Close()
+	})
+
+	It("should answer conditional and range requests for archives", func() {
+		Expect(push(router, "proto", "1.0.0", map[string]string{"a.proto": "contents"}).StatusCode).To(Equal(201))
+		digest := listVersions(router, "")[0].Digest
+
+		auth, err := newAuthenticator(&Settings{})
+		Expect(err).To(BeNil())
+		handler := mux.NewRouter()
+		router.Register(newRouterWrapper(handler, auth, &Settings{}, newMetrics()))
+
+		get := func(headers map[string]string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/v1/projects/common/types/proto/versions/1.0.0/data.tar.gz", nil)
+			for name, value := range headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w
+		}
+
+		w := get(nil)
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Header().Get("ETag")).To(Equal(`"` + digest + `"`))
+		Expect(w.Header().Get("Cache-Control")).To(Equal("public, max-age=31536000, immutable"))
+		Expect(w.Header().Get("Last-Modified")).ToNot(BeEmpty())
+		size := w.Body.Len()
+
+		w = get(map[string]string{"If-None-Match": `"` + digest + `"`})
+		Expect(w.Code).To(Equal(304))
+		Expect(w.Body.Len()).To(Equal(0))
+
+		w = get(map[string]string{"If-None-Match": `"sha256:other"`})
+		Expect(w.Code).To(Equal(200))
+
+		w = get(map[string]string{"Range": "bytes=0-9"})
+		Expect(w.Code).To(Equal(206))
+		Expect(w.Body.Len()).To(Equal(10))
+		Expect(w.Header().Get("Content-Range")).To(Equal(fmt.Sprintf("bytes 0-9/%d", size)))
+	})
+
+	It("should answer range requests with the whole archive when storage cannot seek", func() {
+		Expect(push(router, "proto", "1.0.0", map[string]string{"a.proto": "contents"}).StatusCode).To(Equal(201))
+		digest := listVersions(router, "")[0].Digest
+
+		auth, err := newAuthenticator(&Settings{})
+		Expect(err).To(BeNil())
+		handler := mux.NewRouter()
+		unseekable := newProjectRouter(unseekableStorage{router.storage}, &Settings{})
+		unseekable.Register(newRouterWrapper(handler, auth, &Settings{}, newMetrics()))
+
+		get := func(headers map[string]string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/v1/projects/common/types/proto/versions/1.0.0/data.tar.gz", nil)
+			for name, value := range headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w
+		}
+
+		w := get(nil)
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Header().Get("Accept-Ranges")).To(BeEmpty())
+		size := w.Body.Len()
+
+		w = get(map[string]string{"Range": "bytes=0-9"})
+		Expect(w.Code).To(Equal(200))
+		Expect(w.Header().Get("Content-Range")).To(BeEmpty())
+		Expect(w.Body.Len()).To(Equal(size))
+
+		w = get(map[string]string{"If-None-Match": `"` + digest + `"`})
+		Expect(w.Code).To(Equal(304))
+	})
+
+	It("should refuse request bodies over the limit", func() {
+		auth, err := newAuthenticator(&Settings{})
+		Expect(err).To(BeNil())
+		handler := mux.NewRouter()
+		router.Register(newRouterWrapper(handler, auth, &Settings{MaxBodySize: 64}, newMetrics()))
+
+		body := testArchive(map[string]string{"a.proto": strings.Repeat("large ", 100)})
+		Expect(len(body)).To(BeNumerically(">", 64))
+
+		announced := httptest.NewRequest(http.MethodPost, "/v1/projects/common/types/proto/versions/1.0.0", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, announced)
+		Expect(w.Code).To(Equal(413))
+		Expect(decodeError(w)).To(Equal(apiError{
+			Code:      "too_large",
+			Message:   "request body is larger than the limit of 64 bytes",
+			RequestID: w.Header().Get("X-Request-Id"),
+		}))
+
+		streamed := httptest.NewRequest(http.MethodPost, "/v1/projects/common/types/proto/versions/1.0.0", ioutil.NopCloser(bytes.NewReader(body)))
+		streamed.ContentLength = -1
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, streamed)
+		Expect(w.Code).To(Equal(413))
+
+		Expect(router.storage.Exists("/projects/common/proto/1.0.0")).To(BeFalse())
+	})
+
+	It("should describe errors as json with the request id", func() {
+		auth, err := newAuthenticator(&Settings{})
+		Expect(err).To(BeNil())
+		handler := mux.NewRouter()
+		router.Register(newRouterWrapper(handler, auth, &Settings{}, newMetrics()))
+
+		req := httptest.NewRequest(http.MethodGet, "/v1/projects/missing/types/proto/versions/1.0.0/data.tar.gz", nil)
+		req.Header.Set("X-Request-Id", "from-proxy")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(404))
+		Expect(decodeError(w)).To(Equal(apiError{
+			Code:      "not_found",
+			Message:   "project 'missing' does not exist",
+			RequestID: "from-proxy",
+		}))
+
+		upload := testEntries(tar.Header{Name: "../escape.proto", Typeflag: tar.TypeReg})
+		req = httptest.NewRequest(http.MethodPost, "/v1/projects/common/types/proto/versions/1.0.0", bytes.NewReader(upload))
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(400))
+
+		e := decodeError(w)
+		Expect(e.Code).To(Equal("invalid_archive"))
+		Expect(e.RequestID).ToNot(BeEmpty())
+		Expect(e.RequestID).To(Equal(w.Header().Get("X-Request-Id")))
+		Expect(e.Details).To(Equal(map[string]interface{}{"reason": "path-traversal", "entry": "../escape.proto"}))
+	})
+
+	It("should only let admins overwrite published versions", func() {
+		Expect(push(router, "proto", "1.0.0", map[string]string{"a.proto": "1"}).StatusCode).To(Equal(201))
+		published := listVersions(router, "")[0].Digest
+
+		overwrite := func(force bool, identity *Identity) int {
+			query := url.Values{}
+			if force {
+				query.Set("force", "true")
+			}
+			response, err := router.submitVersion(HttpContext{
+				Args:     map[string]string{"project": "common", "type": "proto", "version": "1.0.0"},
+				Query:    query,
+				Body:     ioutil.NopCloser(bytes.NewReader(testArchive(map[string]string{"a.proto": "2"}))),
+				Identity: identity,
+			})
+			Expect(err).To(BeNil())
+			return response.StatusCode
+		}
+
+		Expect(overwrite(false, &Identity{Name: "ci"})).To(Equal(409))
+		Expect(overwrite(true, &Identity{Name: "ci"})).To(Equal(403))
+		Expect(overwrite(false, &Identity{Name: "admin", Admin: true})).To(Equal(409))
+		Expect(listVersions(router, "")[0].Digest).To(Equal(published))
+
+		Expect(overwrite(true, &Identity{Name: "admin", Admin: true})).To(Equal(201))
+		Expect(listVersions(router, "")[0].Digest).To(Equal(fmt.Sprintf("sha256:%x", sha256.Sum256(testArchive(map[string]string{"a.proto": "2"})))))
+	})
+
+	It("should record dependencies sent with the archive", func() {
+		args := map[string]string{"project": "common", "type": "proto", "version": "1.0.0"}
+		submit := func(dependencies string, query url.Values, identity *Identity) int {
+			header := http.Header{}
+			if dependencies != "" {
+				header.Set(dependenciesHeader, dependencies)
+			}
+			response, err := router.submitVersion(HttpContext{
+				Args:     args,
+				Query:    query,
+				Header:   header,
+				Body:     ioutil.NopCloser(bytes.NewReader(testArchive(map[string]string{"a.proto": ""}))),
+				Identity: identity,
+			})
+			Expect(err).To(BeNil())
+			return response.StatusCode
+		}
+		listed := func() []dependency {
+			response, err := router.listDependencies(HttpContext{Args: args})
+			Expect(err).To(BeNil())
+			Expect(response.StatusCode).To(Equal(200))
+			return response.Model.([]dependency)
+		}
+
+		Expect(submit(`[{"name": "base"}]`, url.Values{}, &Identity{Name: "ci"})).To(Equal(400))
+		response, err := router.getVersion(HttpContext{Args: args})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(404))
+
+		Expect(submit(`[{"name": "base", "type": "proto", "version": "^1.0.0", "repository": "http://idl"}]`, url.Values{}, &Identity{Name: "ci"})).To(Equal(201))
+		Expect(listed()).To(Equal([]dependency{{Name: "base", Type: "proto", Version: "^1.0.0", Repository: "http://idl"}}))
+
+		// an overwrite without dependencies does not keep the old ones
+		Expect(submit("", url.Values{"force": []string{"true"}}, &Identity{Name: "admin", Admin: true})).To(Equal(201))
+		Expect(listed()).To(BeEmpty())
+	})
+
+	It("should audit pushes and overwrites for admins to read", func() {
+		Expect(push(router, "proto", "1.0.0", map[string]string{"a.proto": "1"}).StatusCode).To(Equal(201))
+
+		response, err := router.submitVersion(HttpContext{
+			Args:      map[string]string{"project": "common", "type": "proto", "version": "1.0.0"},
+			Query:     url.Values{"force": []string{"true"}},
+			Body:      ioutil.NopCloser(bytes.NewReader(testArchive(map[string]string{"a.proto": "2"}))),
+			Identity:  &Identity{Name: "admin", Admin: true},
+			RequestID: "overwrite-request",
+		})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(201))
+
+		response, err = router.listAudit(HttpContext{Query: url.Values{}, Identity: &Identity{Name: "ci"}})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(403))
+
+		response, err = router.listAudit(HttpContext{Query: url.Values{}, Identity: &Identity{Name: "admin", Admin: true}})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(200))
+
+		entries := response.Model.([]auditEntry)
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Action).To(Equal("overwrite"))
+		Expect(entries[0].Actor).To(Equal("admin"))
+		Expect(entries[0].RequestID).To(Equal("overwrite-request"))
+		Expect(entries[0].Digest).To(Equal(listVersions(router, "")[0].Digest))
+		Expect(entries[1].Action).To(Equal("push"))
+		Expect(entries[1].Actor).To(Equal("ci"))
+		Expect(entries[1].Version).To(Equal("1.0.0"))
+
+		response, err = router.listAudit(HttpContext{Query: url.Values{"actor": []string{"ci"}}, Identity: &Identity{Name: "admin", Admin: true}})
+		Expect(err).To(BeNil())
+		Expect(response.Model.([]auditEntry)).To(HaveLen(1))
+
+		response, err = router.listAudit(HttpContext{Query: url.Values{"since": []string{"yesterday"}}, Identity: &Identity{Name: "admin", Admin: true}})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(400))
+	})
+
+	It("should log audit entries that cannot be stored without failing the change", func() {
+		logger, hook := logtest.NewNullLogger()
+		router.settings.Log = logger
+		router.storage = auditFailingStorage{router.storage}
+
+		Expect(push(router, "proto", "1.0.0", map[string]string{"a.proto": "1"}).StatusCode).To(Equal(201))
+		Expect(listVersions(router, "")).To(HaveLen(1))
+
+		entry := hook.LastEntry()
+		Expect(entry).ToNot(BeNil())
+		Expect(entry.Level).To(Equal(logrus.ErrorLevel))
+		Expect(entry.Data["audit"]).To(Equal("push"))
+		Expect(entry.Data["version"]).To(Equal("1.0.0"))
+		Expect(entry.Data[logrus.ErrorKey]).To(MatchError("failed to write audit entry: audit log is full"))
+	})
+
+	It("should hide yanked versions from listings but keep serving them", func() {
+		for _, version := range []string{"1.0.0", "1.1.0"} {
+			Expect(push(router, "proto", version, map[string]string{"a.proto": version}).StatusCode).To(Equal(201))
+		}
+		args := map[string]string{"project": "common", "type": "proto", "version": "1.1.0"}
+
+		response, err := router.yankVersion(HttpContext{
+			Args:     args,
+			Body:     bytes.NewBufferString(`{"reason": "breaks clients"}`),
+			Identity: &Identity{Name: "ci"},
+		})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(200))
+
+		Expect(versionNames(listVersions(router, ""))).To(Equal([]string{"1.0.0"}))
+		Expect(versionNames(listVersions(router, "latest=true"))).To(Equal([]string{"1.0.0"}))
+
+		yanked := listVersions(router, "yanked=true")
+		Expect(versionNames(yanked)).To(Equal([]string{"1.0.0", "1.1.0"}))
+		Expect(yanked[1].Yanked).To(BeTrue())
+		Expect(yanked[1].YankReason).To(Equal("breaks clients"))
+
+		pulled, err := router.pullVersion(HttpContext{Args: args})
+		Expect(err).To(BeNil())
+		Expect(pulled.StatusCode).To(Equal(200))
+		pulled.Data.Close()
+
+		response, err = router.unyankVersion(HttpContext{Args: args, Identity: &Identity{Name: "ci"}})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(200))
+		Expect(versionNames(listVersions(router, ""))).To(Equal([]string{"1.0.0", "1.1.0"}))
+	})
+
+	It("should only let admins delete versions", func() {
+		files := map[string]string{"a.proto": "shared"}
+		Expect(push(router, "proto", "1.0.0", files).StatusCode).To(Equal(201))
+		Expect(push(router, "proto", "1.1.0", files).StatusCode).To(Equal(201))
+		Expect(push(router, "proto", "2.0.0", map[string]string{"a.proto": "own"}).StatusCode).To(Equal(201))
+
+		remove := func(version string, identity *Identity) int {
+			response, err := router.deleteVersion(HttpContext{
+				Args:     map[string]string{"project": "common", "type": "proto", "version": version},
+				Identity: identity,
+			})
+			Expect(err).To(BeNil())
+			return response.StatusCode
+		}
+		admin := &Identity{Name: "admin", Admin: true}
+
+		Expect(remove("1.0.0", &Identity{Name: "ci"})).To(Equal(403))
+		Expect(remove("3.0.0", admin)).To(Equal(404))
+
+		Expect(remove("1.0.0", admin)).To(Equal(200))
+		Expect(remove("2.0.0", admin)).To(Equal(200))
+		Expect(versionNames(listVersions(router, ""))).To(Equal([]string{"1.1.0"}))
+
+		// the archive 1.1.0 shares with 1.0.0 is kept
+		Expect(blobFiles(dir)).To(HaveLen(1))
+
+		response, err := router.listAudit(HttpContext{Query: url.Values{"action": []string{"delete"}}, Identity: admin})
+		Expect(err).To(BeNil())
+		Expect(response.Model.([]auditEntry)).To(HaveLen(2))
+	})
+
+	It("should mark projects, types and versions as deprecated", func() {
+		for _, version := range []string{"1.0.0", "1.1.0"} {
+			Expect(push(router, "proto", version, map[string]string{"a.proto": version}).StatusCode).To(Equal(201))
+		}
+		deprecate := func(args map[string]string, body string) int {
+			response, err := router.deprecate(HttpContext{Args: args, Body: bytes.NewBufferString(body), Identity: &Identity{Name: "ci"}})
+			Expect(err).To(BeNil())
+			return response.StatusCode
+		}
+
+		Expect(deprecate(map[string]string{"project": "common"}, `{}`)).To(Equal(400))
+		Expect(deprecate(map[string]string{"project": "missing"}, `{"message": "gone"}`)).To(Equal(404))
+
+		Expect(deprecate(map[string]string{"project": "common"}, `{"message": "moved", "replacement": "example/v2"}`)).To(Equal(200))
+		Expect(deprecate(map[string]string{"project": "common", "type": "proto", "version": "1.0.0"}, `{"message": "has a bug"}`)).To(Equal(200))
+
+		versions := listVersions(router, "")
+		Expect(versions[0].Deprecated).To(Equal(&deprecation{Scope: "version", Message: "has a bug"}))
+		Expect(versions[1].Deprecated).To(Equal(&deprecation{Scope: "project", Message: "moved", Replacement: "example/v2"}))
+
+		marker := func(args map[string]string) *deprecation {
+			response, err := router.getDeprecation(HttpContext{Args: args})
+			Expect(err).To(BeNil())
+			Expect(response.StatusCode).To(Equal(200))
+			return response.Model.(*deprecation)
+		}
+		moved := &deprecation{Scope: "project", Message: "moved", Replacement: "example/v2"}
+		Expect(marker(map[string]string{"project": "common", "type": "proto", "version": "1.1.0"})).To(Equal(moved))
+		Expect(marker(map[string]string{"project": "common", "type": "proto"})).To(Equal(moved))
+		Expect(marker(map[string]string{"project": "common"})).To(Equal(moved))
+
+		withDeprecations := url.Values{"deprecations": []string{"true"}}
+		projects, err := router.listHandler(HttpContext{Query: withDeprecations})
+		Expect(err).To(BeNil())
+		Expect(projects.Model).To(Equal([]projectInfo{{Name: "common", Deprecated: moved}}))
+
+		types, err := router.listTypeHandler(HttpContext{Args: map[string]string{"project": "common"}, Query: withDeprecations})
+		Expect(err).To(BeNil())
+		Expect(types.Model).To(Equal([]typeInfo{{Name: "proto", Deprecated: moved}}))
+
+		// older clients expect plain names
+		projects, err = router.listHandler(HttpContext{Query: url.Values{}})
+		Expect(err).To(BeNil())
+		Expect(projects.Model).To(Equal([]string{"common"}))
+
+		types, err = router.listTypeHandler(HttpContext{Args: map[string]string{"project": "common"}, Query: url.Values{}})
+		Expect(err).To(BeNil())
+		Expect(types.Model).To(Equal([]string{"proto"}))
+
+		response, err := router.undeprecate(HttpContext{Args: map[string]string{"project": "common"}, Identity: &Identity{Name: "ci"}})
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(200))
+
+		versions = listVersions(router, "")
+		Expect(versions[0].Deprecated).ToNot(BeNil())
+		Expect(versions[1].Deprecated).To(BeNil())
+		Expect(marker(map[string]string{"project": "common", "type": "proto", "version": "1.1.0"})).To(BeNil())
+		Expect(marker(map[string]string{"project": "common", "type": "proto", "version": "1.0.0"}).Message).To(Equal("has a bug"))
+
+		projects, err = router.listHandler(HttpContext{Query: withDeprecations})
+		Expect(err).To(BeNil())
+		Expect(projects.Model).To(Equal([]projectInfo{{Name: "common"}}))
+	})
+})
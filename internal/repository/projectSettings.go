@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/syncromatics/idl-repository/internal/compatibility"
+)
+
+type projectSettings struct {
+	Compatibility compatibility.Mode `json:"compatibility"`
+	// Types overrides the compatibility mode for some types, such as openapi
+	Types map[string]compatibility.Mode `json:"types,omitempty"`
+}
+
+func (s *projectSettings) modeFor(idlType string) compatibility.Mode {
+	if mode, ok := s.Types[idlType]; ok {
+		return mode
+	}
+	return s.Compatibility
+}
+
+func (r *projectRouter) getSettings(ctx HttpContext) (*JsonResponse, error) {
+	project, ok := ctx.Args["project"]
+	if !ok {
+		return nil, errors.New("failed to get project from args")
+	}
+
+	settings, err := r.projectSettings(project)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JsonResponse{
+		StatusCode: 200,
+		Model:      settings,
+	}, nil
+}
+
+func (r *projectRouter) putSettings(ctx HttpContext) (*JsonResponse, error) {
+	project, ok := ctx.Args["project"]
+	if !ok {
+		return nil, errors.New("failed to get project from args")
+	}
+
+	settings := projectSettings{}
+	err := json.NewDecoder(ctx.Body).Decode(&settings)
+	if err != nil {
+		return &JsonResponse{
+			StatusCode: 400,
+			Model:      fmt.Sprintf("invalid settings: %s", err),
+		}, nil
+	}
+
+	settings.Compatibility, err = compatibility.ParseMode(string(settings.Compatibility))
+	if err != nil {
+		return &JsonResponse{
+			StatusCode: 400,
+			Model:      err.Error(),
+		}, nil
+	}
+
+	for idlType, mode := range settings.Types {
+		settings.Types[idlType], err = compatibility.ParseMode(string(mode))
+		if err != nil {
+			return &JsonResponse{
+				StatusCode: 400,
+				Model:      err.Error(),
+			}, nil
+		}
+	}
+
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode settings")
+	}
+
+	pth := fmt.Sprintf("/projects/%s", project)
+	err = r.storage.MkDir(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.storage.CreateFile(pth+"/settings.json", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	r.audit(ctx, auditEntry{
+		Action:  "update-settings",
+		Project: project,
+	})
+
+	return &JsonResponse{
+		StatusCode: 200,
+		Model:      settings,
+	}, nil
+}
+
+// projectSettings reads the settings a project was given, falling back to the server defaults
+func (r *projectRouter) projectSettings(project string) (*projectSettings, error) {
+	settings := &projectSettings{
+		Compatibility: r.settings.DefaultCompatibility,
+	}
+	if settings.Compatibility == "" {
+		settings.Compatibility = compatibility.None
+	}
+
+	pth := fmt.Sprintf("/projects/%s/settings.json", project)
+	if !r.storage.Exists(pth) {
+		return settings, nil
+	}
+
+	f, err := r.storage.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = json.NewDecoder(f).Decode(settings)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode project settings")
+	}
+
+	return settings, nil
+}
@@ -2,9 +2,13 @@ package repository
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -13,11 +17,13 @@ import (
 
 type Storage interface {
 	ListFolders(path string) ([]string, error)
+	ListFiles(path string) ([]string, error)
 	File(path string) (io.Reader, error)
 	Exists(path string) bool
 	MkDir(path string) error
 	CreateFile(path string, file io.Reader) error
 	ReadFile(path string) (io.ReadCloser, error)
+	Remove(path string) error
 }
 
 type JsonResponse struct {
@@ -29,16 +35,28 @@ type DataResponse struct {
 	StatusCode int
 	Data       io.ReadCloser
 	Error      string
+	// ETag and Modified are sent when set so clients can make conditional requests
+	ETag     string
+	Modified time.Time
+	// Immutable data never changes once served and can be cached indefinitely
+	Immutable bool
+	// Private data is only kept by the client even if anonymous callers can read the route
+	Private bool
 }
 
 type Muxer interface {
-	RegisterJson(path string, handler func(HttpContext) (*JsonResponse, error))
-	RegisterData(path string, handler func(HttpContext) (*DataResponse, error))
+	RegisterJson(method string, path string, handler func(HttpContext) (*JsonResponse, error))
+	RegisterData(method string, path string, handler func(HttpContext) (*DataResponse, error))
 }
 
 type HttpContext struct {
-	Args map[string]string
-	Body io.Reader
+	Args     map[string]string
+	Query    url.Values
+	Header   http.Header
+	Body     io.Reader
+	Identity *Identity
+	// RequestID is sent back in X-Request-Id and error responses
+	RequestID string
 }
 
 type Server struct {
@@ -51,41 +69,153 @@ func NewServer(settings *Settings, storage Storage) *Server {
 }
 
 func (s *Server) Run(ctx context.Context) func() error {
+	m := newMetrics()
+	storage := instrumentStorage(s.storage, m)
+
 	r := mux.NewRouter()
-	project := newProjectRouter(s.storage)
+	project := newProjectRouter(storage, s.settings)
+	health := newHealthRouter(storage, s.settings)
 
-	wrap := newRouterWrapper(r)
+	auth, err := newAuthenticator(s.settings)
+	if err != nil {
+		return func() error {
+			return errors.Wrap(err, "failed to configure authentication")
+		}
+	}
+
+	wrap := newRouterWrapper(r, auth, s.settings, m)
 
 	project.Register(wrap)
+	health.Register(wrap)
+
+	// probes are answered without authentication and are not logged
+	r.HandleFunc("/healthz", health.healthz).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/readyz", health.readyz).Methods(http.MethodGet, http.MethodHead)
+
+	servers := []*http.Server{}
+	if s.settings.MetricsPort == 0 {
+		r.Handle("/metrics", m.handler())
+	} else {
+		servers = append(servers, &http.Server{
+			Addr:              fmt.Sprintf(":%d", s.settings.MetricsPort),
+			Handler:           adminHandler(m, health),
+			ReadHeaderTimeout: s.settings.ReadHeaderTimeout,
+			IdleTimeout:       s.settings.IdleTimeout,
+		})
+	}
 
-	r.PathPrefix("/").HandlerFunc(s.handle404)
+	r.NotFoundHandler = wrap.logRequests("", http.HandlerFunc(s.handle404))
+	r.MethodNotAllowedHandler = wrap.logRequests("", http.HandlerFunc(s.handle405))
 
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", s.settings.Port),
-		Handler: r,
+		Addr:              fmt.Sprintf(":%d", s.settings.Port),
+		Handler:           r,
+		ReadTimeout:       s.settings.ReadTimeout,
+		ReadHeaderTimeout: s.settings.ReadHeaderTimeout,
+		WriteTimeout:      s.settings.WriteTimeout,
+		IdleTimeout:       s.settings.IdleTimeout,
 	}
 
-	cancel := make(chan error)
+	listener, err := s.listen()
+	if err != nil {
+		return func() error {
+			return err
+		}
+	}
+
+	cancel := make(chan error, 1+len(servers))
 
 	go func() {
-		err := srv.ListenAndServe()
-		if err != nil {
+		err := srv.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
 			cancel <- errors.Wrap(err, "failed to serve http")
 		}
 	}()
 
+	for _, extra := range servers {
+		go func(extra *http.Server) {
+			err := extra.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				cancel <- errors.Wrap(err, "failed to serve metrics")
+			}
+		}(extra)
+	}
+
 	return func() error {
+		var err error
 		select {
 		case <-ctx.Done():
-			// do nothing for now
-			return nil
-		case msg := <-cancel:
-			return msg
+			err = s.shutdown(srv)
+		case err = <-cancel:
+			srv.Close()
+		}
+
+		for _, extra := range servers {
+			extra.Close()
 		}
+		return err
 	}
 }
 
+// adminHandler serves metrics and probes on the plain http metrics port, so probes
+// keep working when the main port requires client certificates
+func adminHandler(m *metrics, health *healthRouter) http.Handler {
+	r := mux.NewRouter()
+	r.Handle("/metrics", m.handler())
+	r.HandleFunc("/healthz", health.healthz).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/readyz", health.readyz).Methods(http.MethodGet, http.MethodHead)
+	return r
+}
+
+// listen serves https when a certificate is configured
+func (s *Server) listen() (net.Listener, error) {
+	var reloader *tlsReloader
+	if s.settings.TLSCert != "" || s.settings.TLSKey != "" || s.settings.TLSClientCA != "" {
+		var err error
+		reloader, err = newTLSReloader(s.settings)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to configure tls")
+		}
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.settings.Port))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to listen")
+	}
+
+	if reloader == nil {
+		return listener, nil
+	}
+	return tls.NewListener(listener, reloader.serverConfig()), nil
+}
+
+// shutdown stops accepting connections and waits for requests in flight until the
+// shutdown timeout, after which the remaining connections are closed. Running out of
+// time is still a clean exit.
+func (s *Server) shutdown(srv *http.Server) error {
+	ctx := context.Background()
+	if s.settings.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.settings.ShutdownTimeout)
+		defer cancel()
+	}
+
+	err := srv.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		s.settings.logger().WithField("timeout", s.settings.ShutdownTimeout).Warn("requests were still running at the shutdown timeout and were stopped")
+		srv.Close()
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to shut down http")
+	}
+	return nil
+}
+
 func (s *Server) handle404(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("url not handled %s\n", r.URL)
-	w.WriteHeader(404)
+	writeError(w, http.StatusNotFound, fmt.Sprintf("'%s' is not an api route", r.URL.Path))
+}
+
+func (s *Server) handle405(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("'%s' does not support %s", r.URL.Path, r.Method))
 }
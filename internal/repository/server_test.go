@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	It("should stop requests still running at the shutdown timeout and exit cleanly", func() {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		defer close(release)
+
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		})}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		go srv.Serve(listener)
+
+		failed := make(chan error, 1)
+		go func() {
+			_, err := http.Get("http://" + listener.Addr().String())
+			failed <- err
+		}()
+		<-started
+
+		log := logrus.New()
+		log.Out = ioutil.Discard
+		server := NewServer(&Settings{ShutdownTimeout: 50 * time.Millisecond, Log: log}, nil)
+
+		Expect(server.shutdown(srv)).To(Succeed())
+		Expect(<-failed).ToNot(BeNil())
+	})
+})
@@ -1,5 +1,42 @@
 package repository
 
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/syncromatics/idl-repository/internal/compatibility"
+	goversion "go.hein.dev/go-version"
+)
+
 type Settings struct {
-	Port int
+	Port                 int
+	AdminToken           string
+	Auth                 *AuthSettings
+	DefaultCompatibility compatibility.Mode
+	// MaxFileSize and MaxArchiveSize limit the uncompressed size of uploads in bytes, zero is unlimited
+	MaxFileSize    int64
+	MaxArchiveSize int64
+	// MaxBodySize limits request bodies in bytes as they are sent, zero is unlimited
+	MaxBodySize int64
+
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is how long requests in flight get to finish once the server is stopped
+	ShutdownTimeout time.Duration
+
+	// TLSCert and TLSKey serve https, clients must present a certificate signed by TLSClientCA when it is set
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+
+	// MetricsPort serves /metrics on its own port, zero serves it next to the api
+	MetricsPort int
+
+	// Build is the version reported by /v1/info
+	Build *goversion.Info
+
+	// Log receives request logs, audit entries and errors
+	Log logrus.FieldLogger
 }
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadInterval is how often the certificate files are checked for changes
+const reloadInterval = time.Second
+
+// tlsReloader builds the tls config from the certificate, key and client CA files
+// and builds it again when one of them changes, so certificates can be renewed in place
+type tlsReloader struct {
+	cert     string
+	key      string
+	clientCA string
+	log      logrus.FieldLogger
+
+	mu       sync.Mutex
+	config   *tls.Config
+	modified map[string]time.Time
+	checked  time.Time
+}
+
+func newTLSReloader(settings *Settings) (*tlsReloader, error) {
+	if settings.TLSCert == "" || settings.TLSKey == "" {
+		return nil, errors.New("both a tls certificate and key are required")
+	}
+
+	t := &tlsReloader{
+		cert:     settings.TLSCert,
+		key:      settings.TLSKey,
+		clientCA: settings.TLSClientCA,
+		log:      settings.logger(),
+	}
+
+	err := t.reload()
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *tlsReloader) files() []string {
+	files := []string{t.cert, t.key}
+	if t.clientCA != "" {
+		files = append(files, t.clientCA)
+	}
+	return files
+}
+
+func (t *tlsReloader) reload() error {
+	modified := map[string]time.Time{}
+	for _, f := range t.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return errors.Wrap(err, "failed to read tls files")
+		}
+		modified[f] = info.ModTime()
+	}
+	// a file that fails to load is only tried again once it changes
+	t.modified = modified
+
+	certificate, err := tls.LoadX509KeyPair(t.cert, t.key)
+	if err != nil {
+		return errors.Wrap(err, "failed to load tls certificate")
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	if t.clientCA != "" {
+		b, err := ioutil.ReadFile(t.clientCA)
+		if err != nil {
+			return errors.Wrap(err, "failed to read client CA bundle")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return errors.New(fmt.Sprintf("client CA bundle '%s' does not contain any certificates", t.clientCA))
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	t.config = config
+	return nil
+}
+
+// configForClient is used for every handshake, a renewal that cannot be loaded is
+// reported and the previous certificate keeps being served
+func (t *tlsReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.checked) < reloadInterval {
+		return t.config, nil
+	}
+	t.checked = now
+
+	if t.changed() {
+		err := t.reload()
+		if err != nil {
+			t.log.WithError(err).Error("failed to reload tls certificate")
+		}
+	}
+
+	return t.config, nil
+}
+
+func (t *tlsReloader) changed() bool {
+	for _, f := range t.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(t.modified[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tlsReloader) serverConfig() *tls.Config {
+	return &tls.Config{GetConfigForClient: t.configForClient}
+}
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+// newTestCertificate signs a certificate with parent, or itself when parent is nil
+func newTestCertificate(name string, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	Expect(err).To(BeNil())
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.certificate, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	Expect(err).To(BeNil())
+
+	certificate, err := x509.ParseCertificate(der)
+	Expect(err).To(BeNil())
+	return &testCertificate{certificate, key}
+}
+
+// write stores the certificate and key as PEM files in directory
+func (c *testCertificate) write(directory string, name string) (string, string) {
+	cert := filepath.Join(directory, name+".pem")
+	Expect(ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.certificate.Raw}), 0600)).To(Succeed())
+
+	der, err := x509.MarshalECPrivateKey(c.key)
+	Expect(err).To(BeNil())
+
+	key := filepath.Join(directory, name+"-key.pem")
+	Expect(ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)).To(Succeed())
+	return cert, key
+}
+
+var _ = Describe("tlsReloader", func() {
+	var (
+		directory string
+		ca        *testCertificate
+		settings  *Settings
+	)
+
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "idl-tls")
+		Expect(err).To(BeNil())
+
+		ca = newTestCertificate("ca", nil)
+		caFile, _ := ca.write(directory, "ca")
+		cert, key := newTestCertificate("server", ca).write(directory, "server")
+
+		settings = &Settings{TLSCert: cert, TLSKey: key, TLSClientCA: caFile}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(directory)
+	})
+
+	serve := func(reloader *tlsReloader) *httptest.Server {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		}))
+		server.TLS = reloader.serverConfig()
+		server.StartTLS()
+		return server
+	}
+
+	client := func(certificates ...tls.Certificate) *http.Client {
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.certificate)
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      pool,
+			Certificates: certificates,
+		}}}
+	}
+
+	clientCertificate := func(c *testCertificate) tls.Certificate {
+		cert, key := c.write(directory, "client")
+		certificate, err := tls.LoadX509KeyPair(cert, key)
+		Expect(err).To(BeNil())
+		return certificate
+	}
+
+	It("should require client certificates signed by the client CA", func() {
+		reloader, err := newTLSReloader(settings)
+		Expect(err).To(BeNil())
+		server := serve(reloader)
+		defer server.Close()
+
+		resp, err := client(clientCertificate(newTestCertificate("ci", ca))).Get(server.URL)
+		Expect(err).To(BeNil())
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		Expect(string(b)).To(Equal("ci"))
+
+		_, err = client().Get(server.URL)
+		Expect(err).ToNot(BeNil())
+
+		_, err = client(clientCertificate(newTestCertificate("stranger", nil))).Get(server.URL)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should serve renewed certificates", func() {
+		reloader, err := newTLSReloader(settings)
+		Expect(err).To(BeNil())
+		first := reloader.config.Certificates[0].Certificate[0]
+
+		newTestCertificate("renewed", ca).write(directory, "server")
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(settings.TLSCert, later, later)).To(Succeed())
+		reloader.checked = time.Time{}
+
+		config, err := reloader.configForClient(nil)
+		Expect(err).To(BeNil())
+		Expect(config.Certificates[0].Certificate[0]).ToNot(Equal(first))
+	})
+
+	It("should keep the previous certificate when a renewal cannot be loaded", func() {
+		reloader, err := newTLSReloader(settings)
+		Expect(err).To(BeNil())
+		first := reloader.config
+
+		Expect(ioutil.WriteFile(settings.TLSKey, []byte("not a key"), 0600)).To(Succeed())
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(settings.TLSKey, later, later)).To(Succeed())
+		reloader.checked = time.Time{}
+
+		config, err := reloader.configForClient(nil)
+		Expect(err).To(BeNil())
+		Expect(config).To(Equal(first))
+	})
+
+	It("should need both a certificate and a key", func() {
+		_, err := newTLSReloader(&Settings{TLSCert: settings.TLSCert})
+		Expect(err).ToNot(BeNil())
+	})
+})
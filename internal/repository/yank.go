@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+type yankRequest struct {
+	Reason string `json:"reason"`
+}
+
+// yankVersion hides a version from listings, so ranges no longer resolve to it,
+// while lock files that pin it can still pull it
+func (r *projectRouter) yankVersion(ctx HttpContext) (*JsonResponse, error) {
+	request := yankRequest{}
+	err := json.NewDecoder(ctx.Body).Decode(&request)
+	if err != nil && err != io.EOF {
+		return &JsonResponse{
+			StatusCode: 400,
+			Model:      fmt.Sprintf("invalid yank request: %s", err),
+		}, nil
+	}
+
+	return r.setYanked(ctx, "yank", true, request.Reason)
+}
+
+func (r *projectRouter) unyankVersion(ctx HttpContext) (*JsonResponse, error) {
+	return r.setYanked(ctx, "unyank", false, "")
+}
+
+func (r *projectRouter) setYanked(ctx HttpContext, action string, yanked bool, reason string) (*JsonResponse, error) {
+	project, idlType, version, err := versionArgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info, _, ok, err := r.findArchive(project, idlType, version)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &JsonResponse{
+			StatusCode: 404,
+			Model:      fmt.Sprintf("project '%s' with type '%s' does not have version '%s'", project, idlType, version),
+		}, nil
+	}
+
+	info.Yanked = yanked
+	info.YankReason = reason
+
+	err = r.writeMetadata(fmt.Sprintf("/projects/%s/%s/%s", project, idlType, version), info)
+	if err != nil {
+		return nil, err
+	}
+
+	r.audit(ctx, auditEntry{
+		Action:  action,
+		Project: project,
+		Type:    idlType,
+		Version: version,
+		Digest:  info.Digest,
+	})
+
+	return &JsonResponse{
+		StatusCode: 200,
+		Model:      info,
+	}, nil
+}
+
+// deleteVersion removes a version for good, its archive goes too unless another version shares it
+func (r *projectRouter) deleteVersion(ctx HttpContext) (*JsonResponse, error) {
+	project, idlType, version, err := versionArgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Identity == nil || !ctx.Identity.Admin {
+		return &JsonResponse{
+			StatusCode: 403,
+			Model:      "only admins can delete published versions",
+		}, nil
+	}
+
+	pth := fmt.Sprintf("/projects/%s/%s/%s", project, idlType, version)
+	if !r.storage.Exists(pth) {
+		return &JsonResponse{
+			StatusCode: 404,
+			Model:      fmt.Sprintf("project '%s' with type '%s' does not have version '%s'", project, idlType, version),
+		}, nil
+	}
+
+	info, err := r.readMetadata(fmt.Sprintf("/projects/%s/%s", project, idlType), version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.storage.Remove(pth)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to delete version '%s'", version)
+	}
+
+	err = r.releaseBlob(info.Digest, blobRef{project, idlType, version})
+	if err != nil {
+		return nil, err
+	}
+
+	r.audit(ctx, auditEntry{
+		Action:  "delete",
+		Project: project,
+		Type:    idlType,
+		Version: version,
+		Digest:  info.Digest,
+	})
+
+	return &JsonResponse{
+		StatusCode: 200,
+		Model:      info,
+	}, nil
+}
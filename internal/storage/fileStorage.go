@@ -1,21 +1,27 @@
 package storage
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
+const tempPrefix = ".upload-"
+
 type FileStorage struct {
 	basePath string
+	log      logrus.FieldLogger
 }
 
-func NewFileStorage(basePath string) (*FileStorage, error) {
+// NewFileStorage stores modules under basePath, log hears about incomplete uploads
+// removed on start and may be nil
+func NewFileStorage(basePath string, log logrus.FieldLogger) (*FileStorage, error) {
 	absBasePath, err := filepath.Abs(basePath)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed determining absolute directory")
@@ -28,7 +34,37 @@ func NewFileStorage(basePath string) (*FileStorage, error) {
 		}
 	}
 
-	return &FileStorage{absBasePath}, nil
+	storage := &FileStorage{absBasePath, log}
+
+	err = storage.removeOrphans()
+	if err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// removeOrphans deletes temporary files left behind by uploads that never finished
+func (s *FileStorage) removeOrphans() error {
+	err := filepath.Walk(s.basePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() && strings.HasPrefix(info.Name(), tempPrefix) {
+			if s.log != nil {
+				s.log.WithField("path", path).Info("removing incomplete upload")
+			}
+			return os.Remove(path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed removing incomplete uploads")
+	}
+
+	return nil
 }
 
 func (s *FileStorage) ListFolders(path string) ([]string, error) {
@@ -60,6 +96,31 @@ func (s *FileStorage) ListFolders(path string) ([]string, error) {
 	return directories, nil
 }
 
+// ListFiles lists the files directly inside path, uploads in progress are left out
+func (s *FileStorage) ListFiles(path string) ([]string, error) {
+	fullPath, err := s.securePath(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not determine secure path")
+	}
+
+	files, err := ioutil.ReadDir(fullPath)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read directory")
+	}
+
+	names := []string{}
+	for _, f := range files {
+		if f.Mode().IsRegular() && !strings.HasPrefix(f.Name(), tempPrefix) {
+			names = append(names, f.Name())
+		}
+	}
+
+	return names, nil
+}
+
 func (s *FileStorage) File(path string) (io.Reader, error) {
 	return nil, nil
 }
@@ -91,39 +152,69 @@ func (s *FileStorage) MkDir(path string) error {
 	return nil
 }
 
+// CreateFile stages the contents in a temporary file next to the destination and only
+// moves it into place once the whole stream has been read, so readers never see a partial file.
 func (s *FileStorage) CreateFile(path string, file io.Reader) error {
 	fullPath, err := s.securePath(path)
 	if err != nil {
 		return errors.Wrap(err, "could not determine secure path")
 	}
 
-	f, err := os.Create(fullPath)
+	f, err := ioutil.TempFile(filepath.Dir(fullPath), tempPrefix)
 	if err != nil {
 		return errors.Wrap(err, "failed creating file")
 	}
-	defer f.Close()
 
-	w := bufio.NewWriter(f)
-	r := bufio.NewReader(file)
-	defer w.Flush()
+	err = writeFile(f, file)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
 
-	buf := make([]byte, 1024)
-	for {
-		// read a chunk
-		n, err := r.Read(buf)
-		if err != nil && err != io.EOF {
-			return errors.Wrap(err, "failed to read from stream")
-		}
-		if n == 0 {
-			break
-		}
+	err = os.Rename(f.Name(), fullPath)
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "failed moving file into place")
+	}
 
-		// write a chunk
-		if _, err := w.Write(buf[:n]); err != nil {
-			return errors.Wrap(err, "failed to write to file")
-		}
+	return nil
+}
+
+func writeFile(f *os.File, file io.Reader) error {
+	_, err := io.Copy(f, file)
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write file")
 	}
 
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to flush file")
+	}
+
+	err = f.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to close file")
+	}
+
+	return nil
+}
+
+// Remove deletes a file or a folder with everything in it, removing what does not exist succeeds
+func (s *FileStorage) Remove(path string) error {
+	fullPath, err := s.securePath(path)
+	if err != nil {
+		return errors.Wrap(err, "could not determine secure path")
+	}
+	if fullPath == s.basePath {
+		return errors.New("the storage root cannot be removed")
+	}
+
+	err = os.RemoveAll(fullPath)
+	if err != nil {
+		return errors.Wrap(err, "failed removing file")
+	}
 	return nil
 }
 
@@ -0,0 +1,124 @@
+package storage_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/syncromatics/idl-repository/internal/storage"
+
+	"github.com/sirupsen/logrus/hooks/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type failingReader struct {
+	data io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection dropped")
+	}
+	return n, err
+}
+
+var _ = Describe("FileStorage", func() {
+	var (
+		directory string
+		store     *storage.FileStorage
+	)
+
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "idl-storage")
+		Expect(err).To(BeNil())
+
+		store, err = storage.NewFileStorage(directory, nil)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(directory)
+	})
+
+	It("should create files", func() {
+		err := store.CreateFile("/data.tar.gz", strings.NewReader("contents"))
+		Expect(err).To(BeNil())
+
+		b, err := ioutil.ReadFile(filepath.Join(directory, "data.tar.gz"))
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal("contents"))
+	})
+
+	It("should list files", func() {
+		Expect(store.MkDir("/audit/nested")).To(Succeed())
+		Expect(store.CreateFile("/audit/1.json", strings.NewReader("{}"))).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(directory, "audit", ".upload-123"), nil, 0600)).To(Succeed())
+
+		files, err := store.ListFiles("/audit")
+		Expect(err).To(BeNil())
+		Expect(files).To(Equal([]string{"1.json"}))
+
+		files, err = store.ListFiles("/missing")
+		Expect(err).To(BeNil())
+		Expect(files).To(BeEmpty())
+	})
+
+	It("should remove files and folders", func() {
+		Expect(store.MkDir("/projects/a/proto/1.0.0")).To(Succeed())
+		Expect(store.CreateFile("/projects/a/proto/1.0.0/metadata.json", strings.NewReader("{}"))).To(Succeed())
+		Expect(store.CreateFile("/data.tar.gz", strings.NewReader("contents"))).To(Succeed())
+
+		Expect(store.Remove("/projects/a/proto/1.0.0")).To(Succeed())
+		Expect(store.Remove("/data.tar.gz")).To(Succeed())
+		Expect(store.Remove("/missing")).To(Succeed())
+
+		Expect(store.Exists("/projects/a/proto/1.0.0")).To(BeFalse())
+		Expect(store.Exists("/projects/a/proto")).To(BeTrue())
+		Expect(store.Exists("/data.tar.gz")).To(BeFalse())
+
+		Expect(store.Remove("/")).ToNot(Succeed())
+	})
+
+	It("should keep the previous file when a write fails", func() {
+		err := store.CreateFile("/data.tar.gz", strings.NewReader("original"))
+		Expect(err).To(BeNil())
+
+		err = store.CreateFile("/data.tar.gz", &failingReader{strings.NewReader("partial")})
+		Expect(err).ToNot(BeNil())
+
+		b, err := ioutil.ReadFile(filepath.Join(directory, "data.tar.gz"))
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal("original"))
+
+		files, err := ioutil.ReadDir(directory)
+		Expect(err).To(BeNil())
+		Expect(files).To(HaveLen(1))
+	})
+
+	It("should remove incomplete uploads on start", func() {
+		err := os.MkdirAll(filepath.Join(directory, "projects", "a"), os.ModePerm)
+		Expect(err).To(BeNil())
+
+		orphan := filepath.Join(directory, "projects", "a", ".upload-123")
+		err = ioutil.WriteFile(orphan, []byte("partial"), 0644)
+		Expect(err).To(BeNil())
+
+		log, hook := test.NewNullLogger()
+		_, err = storage.NewFileStorage(directory, log)
+		Expect(err).To(BeNil())
+
+		_, err = os.Stat(orphan)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		Expect(hook.Entries).To(HaveLen(1))
+		Expect(hook.LastEntry().Message).To(Equal("removing incomplete upload"))
+		Expect(hook.LastEntry().Data["path"]).To(Equal(orphan))
+	})
+})
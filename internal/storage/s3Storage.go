@@ -0,0 +1,615 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	defaultS3Timeout = time.Minute
+)
+
+type S3Settings struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// Timeout bounds connecting to the service and every request to it, zero uses a minute.
+	// Objects streamed by ReadFile are only bounded until the service starts answering.
+	Timeout time.Duration
+}
+
+// S3Storage keeps modules in an S3 compatible bucket. Folders are key prefixes so
+// several servers can share the same bucket.
+type S3Storage struct {
+	endpoint *url.URL
+	settings S3Settings
+	client   *http.Client
+}
+
+type listBucketResult struct {
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	KeyCount              int    `xml:"KeyCount"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func NewS3Storage(settings S3Settings) (*S3Storage, error) {
+	if settings.Bucket == "" {
+		return nil, errors.New("s3 storage requires a bucket")
+	}
+
+	if settings.Region == "" {
+		settings.Region = "us-east-1"
+	}
+
+	if settings.Endpoint == "" {
+		settings.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", settings.Region)
+	}
+
+	endpoint, err := url.Parse(strings.TrimSuffix(settings.Endpoint, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid s3 endpoint")
+	}
+
+	settings.Prefix = strings.Trim(settings.Prefix, "/")
+
+	if settings.Timeout <= 0 {
+		settings.Timeout = defaultS3Timeout
+	}
+
+	return &S3Storage{
+		endpoint: endpoint,
+		settings: settings,
+		client:   &http.Client{Transport: newS3Transport(settings.Timeout)},
+	}, nil
+}
+
+// newS3Transport gives up on a service that stalls instead of holding requests forever
+func newS3Transport(timeout time.Duration) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   16,
+	}
+}
+
+func (s *S3Storage) ListFolders(pth string) ([]string, error) {
+	prefix := s.key(pth) + "/"
+	if prefix == "/" {
+		prefix = ""
+	}
+
+	directories := []string{}
+	token := ""
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+			"delimiter": {"/"},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		result, err := s.list(query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range result.CommonPrefixes {
+			directories = append(directories, strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/"))
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	return directories, nil
+}
+
+func (s *S3Storage) ListFiles(pth string) ([]string, error) {
+	prefix := s.key(pth) + "/"
+	if prefix == "/" {
+		prefix = ""
+	}
+
+	files := []string{}
+	token := ""
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+			"delimiter": {"/"},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		result, err := s.list(query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			files = append(files, strings.TrimPrefix(c.Key, prefix))
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	return files, nil
+}
+
+func (s *S3Storage) File(pth string) (io.Reader, error) {
+	return nil, nil
+}
+
+func (s *S3Storage) Exists(pth string) bool {
+	resp, err := s.do(http.MethodHead, s.key(pth), nil, nil, emptyPayloadHash, 0)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return true
+		}
+	}
+
+	// folders only exist as the prefix of other keys
+	result, err := s.list(url.Values{
+		"list-type": {"2"},
+		"prefix":    {s.key(pth) + "/"},
+		"max-keys":  {"1"},
+	})
+	if err != nil {
+		return false
+	}
+
+	return result.KeyCount > 0
+}
+
+func (s *S3Storage) MkDir(pth string) error {
+	return nil
+}
+
+// CreateFile spools the contents to a local temporary file first. S3 needs the length
+// up front, and nothing is written to the bucket unless the whole stream was read.
+func (s *S3Storage) CreateFile(pth string, file io.Reader) error {
+	f, err := ioutil.TempFile("", tempPrefix)
+	if err != nil {
+		return errors.Wrap(err, "failed creating temporary file")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), file)
+	if err != nil {
+		return errors.Wrap(err, "failed to write file")
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return errors.Wrap(err, "failed to rewind file")
+	}
+
+	resp, err := s.do(http.MethodPut, s.key(pth), nil, f, hex.EncodeToString(hash.Sum(nil)), size)
+	if err != nil {
+		return errors.Wrap(err, "failed uploading object")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError(resp, "failed uploading object")
+	}
+
+	return nil
+}
+
+func (s *S3Storage) ReadFile(pth string) (io.ReadCloser, error) {
+	req, err := s.request(http.MethodGet, s.key(pth), nil, nil, emptyPayloadHash, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// objects are streamed to clients as they come, so only waiting for the answer is bounded
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading object")
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// without a length the object cannot be seeked and is read as it comes
+		if resp.ContentLength < 0 {
+			return resp.Body, nil
+		}
+		return &s3Object{storage: s, key: s.key(pth), size: resp.ContentLength, body: resp.Body}, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("'%s' does not exist", pth))
+	}
+
+	defer resp.Body.Close()
+	return nil, s.responseError(resp, "failed reading object")
+}
+
+// s3Object reads an object and turns seeks into ranged GETs, so range requests
+// for archives only transfer the part that was asked for
+type s3Object struct {
+	storage *S3Storage
+	key     string
+	size    int64
+	offset  int64
+	// body is open at bodyOffset, seeks elsewhere only replace it once read from
+	body       io.ReadCloser
+	bodyOffset int64
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body != nil && o.bodyOffset != o.offset {
+		o.body.Close()
+		o.body = nil
+	}
+
+	if o.body == nil {
+		body, err := o.storage.readFrom(o.key, o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+		o.bodyOffset = o.offset
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	o.bodyOffset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New(fmt.Sprintf("invalid whence %d", whence))
+	}
+	if offset < 0 {
+		return 0, errors.New("cannot seek before the start of an object")
+	}
+
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	return o.body.Close()
+}
+
+// readFrom gets an object from the offset on
+func (s *S3Storage) readFrom(key string, offset int64) (io.ReadCloser, error) {
+	req, err := s.request(http.MethodGet, key, nil, nil, emptyPayloadHash, 0)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading object")
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// services without range support send everything
+		_, err = io.CopyN(ioutil.Discard, resp.Body, offset)
+		if err != nil {
+			resp.Body.Close()
+			return nil, errors.Wrap(err, "failed reading object")
+		}
+		return resp.Body, nil
+	}
+
+	defer resp.Body.Close()
+	return nil, s.responseError(resp, "failed reading object")
+}
+
+// Remove deletes the object at the path and every object under it as a folder
+func (s *S3Storage) Remove(pth string) error {
+	key := s.key(pth)
+	if key == s.settings.Prefix {
+		return errors.New("the storage root cannot be removed")
+	}
+
+	keys := []string{key}
+	token := ""
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {key + "/"},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		result, err := s.list(query)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	for _, k := range keys {
+		resp, err := s.do(http.MethodDelete, k, nil, nil, emptyPayloadHash, 0)
+		if err != nil {
+			return errors.Wrap(err, "failed deleting object")
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+			return s.responseError(resp, "failed deleting object")
+		}
+	}
+
+	return nil
+}
+
+func (s *S3Storage) list(query url.Values) (*listBucketResult, error) {
+	resp, err := s.do(http.MethodGet, "", query, nil, emptyPayloadHash, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed listing objects")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.responseError(resp, "failed listing objects")
+	}
+
+	result := &listBucketResult{}
+	err = xml.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed decoding object list")
+	}
+
+	return result, nil
+}
+
+// key maps a storage path onto an object key under the configured prefix
+func (s *S3Storage) key(pth string) string {
+	key := strings.TrimPrefix(path.Clean("/"+pth), "/")
+	if s.settings.Prefix == "" {
+		return key
+	}
+	if key == "" {
+		return s.settings.Prefix
+	}
+	return s.settings.Prefix + "/" + key
+}
+
+// do sends a request that has to be answered and read within the timeout
+func (s *S3Storage) do(method string, key string, query url.Values, body io.Reader, payloadHash string, size int64) (*http.Response, error) {
+	req, err := s.request(method, key, query, body, payloadHash, size)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.settings.Timeout)
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &timedBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// timedBody releases the request's deadline once the answer is closed
+type timedBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *timedBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// request builds a signed request, headers that are not x-amz- can still be added
+func (s *S3Storage) request(method string, key string, query url.Values, body io.Reader, payloadHash string, size int64) (*http.Request, error) {
+	objectPath := "/" + s.settings.Bucket
+	if key != "" {
+		objectPath += "/" + key
+	}
+
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + objectPath
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed creating request")
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	return req, nil
+}
+
+// sign adds an AWS signature version 4 to the request
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.settings.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.settings.SessionToken)
+	}
+
+	if s.settings.AccessKey == "" {
+		return
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.settings.Region)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.settings.SecretKey), date)
+	key = hmacSHA256(key, s.settings.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.settings.AccessKey, scope, signedHeaders, signature))
+}
+
+func (s *S3Storage) responseError(resp *http.Response, message string) error {
+	e := s3Error{}
+	err := xml.NewDecoder(resp.Body).Decode(&e)
+	if err != nil || e.Code == "" {
+		return errors.New(fmt.Sprintf("%s: status code %d", message, resp.StatusCode))
+	}
+
+	return errors.New(fmt.Sprintf("%s: %s: %s", message, e.Code, e.Message))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := []string{}
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode escapes everything except unreserved characters the way S3 signatures expect
+func uriEncode(text string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
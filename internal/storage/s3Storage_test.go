@@ -0,0 +1,266 @@
+package storage_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syncromatics/idl-repository/internal/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeS3 is an in memory stand in for the parts of the S3 API the storage uses
+type fakeS3 struct {
+	mutex   sync.Mutex
+	bucket  string
+	objects map[string][]byte
+	auth    []string
+	ranges  []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+
+	if !strings.HasPrefix(r.URL.Path, "/"+f.bucket) {
+		w.WriteHeader(404)
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+f.bucket), "/")
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r)
+	case r.Method == http.MethodPut:
+		b, _ := ioutil.ReadAll(r.Body)
+		f.objects[key] = b
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(204)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		b, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			f.ranges = append(f.ranges, r.Header.Get("Range"))
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+	default:
+		w.WriteHeader(405)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+
+	type commonPrefix struct {
+		Prefix string
+	}
+	type content struct {
+		Key string
+	}
+	result := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		KeyCount       int
+		CommonPrefixes []commonPrefix
+		Contents       []content
+	}{}
+
+	seen := map[string]bool{}
+	keys := []string{}
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		result.KeyCount++
+
+		rest := strings.TrimPrefix(key, prefix)
+		if delimiter != "" && strings.Contains(rest, delimiter) {
+			p := prefix + rest[:strings.Index(rest, delimiter)+1]
+			if !seen[p] {
+				seen[p] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{p})
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, content{key})
+	}
+
+	xml.NewEncoder(w).Encode(result)
+}
+
+var _ = Describe("S3Storage", func() {
+	var (
+		fake   *fakeS3
+		server *httptest.Server
+		store  *storage.S3Storage
+	)
+
+	BeforeEach(func() {
+		fake = &fakeS3{bucket: "idls", objects: map[string][]byte{}}
+		server = httptest.NewServer(fake)
+
+		var err error
+		store, err = storage.NewS3Storage(storage.S3Settings{
+			Endpoint:  server.URL,
+			Bucket:    "idls",
+			Prefix:    "/repository/",
+			AccessKey: "access",
+			SecretKey: "secret",
+		})
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should store files under the prefix", func() {
+		err := store.CreateFile("/projects/a/proto/1.0.0/data.tar.gz", strings.NewReader("contents"))
+		Expect(err).To(BeNil())
+
+		Expect(fake.objects).To(HaveKey("repository/projects/a/proto/1.0.0/data.tar.gz"))
+		Expect(fake.auth[0]).To(HavePrefix("AWS4-HMAC-SHA256 Credential=access/"))
+	})
+
+	It("should read files", func() {
+		fake.objects["repository/projects/a/proto/1.0.0/data.tar.gz"] = []byte("contents")
+
+		f, err := store.ReadFile("/projects/a/proto/1.0.0/data.tar.gz")
+		Expect(err).To(BeNil())
+		defer f.Close()
+
+		b, err := ioutil.ReadAll(f)
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal("contents"))
+	})
+
+	It("should read parts of files with ranged requests", func() {
+		fake.objects["repository/blobs/sha256/abc.tar.gz"] = []byte("contents")
+
+		f, err := store.ReadFile("/blobs/sha256/abc.tar.gz")
+		Expect(err).To(BeNil())
+		defer f.Close()
+
+		seeker, ok := f.(io.ReadSeeker)
+		Expect(ok).To(BeTrue())
+
+		// finding the size and rewinding keeps reading the first response
+		size, err := seeker.Seek(0, io.SeekEnd)
+		Expect(err).To(BeNil())
+		Expect(size).To(Equal(int64(8)))
+		_, err = seeker.Seek(0, io.SeekStart)
+		Expect(err).To(BeNil())
+
+		b := make([]byte, 3)
+		_, err = io.ReadFull(seeker, b)
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal("con"))
+		Expect(fake.ranges).To(BeEmpty())
+
+		_, err = seeker.Seek(5, io.SeekStart)
+		Expect(err).To(BeNil())
+		b, err = ioutil.ReadAll(seeker)
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal("nts"))
+		Expect(fake.ranges).To(Equal([]string{"bytes=5-"}))
+	})
+
+	It("should error reading missing files", func() {
+		_, err := store.ReadFile("/projects/a/proto/1.0.0/data.tar.gz")
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should list folders", func() {
+		fake.objects["repository/projects/a/proto/1.0.0/data.tar.gz"] = []byte("")
+		fake.objects["repository/projects/a/avro/1.0.0/data.tar.gz"] = []byte("")
+		fake.objects["repository/projects/b/proto/1.0.0/data.tar.gz"] = []byte("")
+
+		projects, err := store.ListFolders("/projects")
+		Expect(err).To(BeNil())
+		Expect(projects).To(Equal([]string{"a", "b"}))
+
+		types, err := store.ListFolders("/projects/a")
+		Expect(err).To(BeNil())
+		Expect(types).To(Equal([]string{"avro", "proto"}))
+	})
+
+	It("should list files", func() {
+		fake.objects["repository/audit/1.json"] = []byte("")
+		fake.objects["repository/audit/2.json"] = []byte("")
+		fake.objects["repository/audit/nested/3.json"] = []byte("")
+
+		files, err := store.ListFiles("/audit")
+		Expect(err).To(BeNil())
+		Expect(files).To(Equal([]string{"1.json", "2.json"}))
+	})
+
+	It("should remove files and folders", func() {
+		fake.objects["repository/projects/a/proto/1.0.0/metadata.json"] = []byte("")
+		fake.objects["repository/projects/a/proto/1.0.0/dependencies.json"] = []byte("")
+		fake.objects["repository/projects/a/proto/1.0.1/metadata.json"] = []byte("")
+		fake.objects["repository/blobs/sha256/abc.tar.gz"] = []byte("")
+
+		Expect(store.Remove("/projects/a/proto/1.0.0")).To(Succeed())
+		Expect(store.Remove("/blobs/sha256/abc.tar.gz")).To(Succeed())
+
+		Expect(fake.objects).To(HaveLen(1))
+		Expect(fake.objects).To(HaveKey("repository/projects/a/proto/1.0.1/metadata.json"))
+
+		Expect(store.Remove("/")).ToNot(Succeed())
+	})
+
+	It("should give up on a service that stops answering", func() {
+		release := make(chan struct{})
+		stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// listings stop halfway through the answer, everything else before it
+			if r.URL.Query().Get("list-type") != "" {
+				w.WriteHeader(200)
+				w.(http.Flusher).Flush()
+			}
+			<-release
+		}))
+		defer stalled.Close()
+		defer close(release)
+
+		store, err := storage.NewS3Storage(storage.S3Settings{
+			Endpoint: stalled.URL,
+			Bucket:   "idls",
+			Timeout:  50 * time.Millisecond,
+		})
+		Expect(err).To(BeNil())
+
+		_, err = store.ListFolders("/projects")
+		Expect(err).ToNot(BeNil())
+
+		_, err = store.ReadFile("/projects/a/proto/1.0.0/data.tar.gz")
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should find files and folders", func() {
+		fake.objects["repository/projects/a/proto/1.0.0/data.tar.gz"] = []byte("")
+
+		Expect(store.Exists("/projects/a")).To(BeTrue())
+		Expect(store.Exists("/projects/a/proto/1.0.0/data.tar.gz")).To(BeTrue())
+		Expect(store.Exists("/projects/b")).To(BeFalse())
+	})
+})
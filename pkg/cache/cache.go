@@ -0,0 +1,374 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syncromatics/idl-repository/pkg/config"
+
+	"github.com/pkg/errors"
+)
+
+var sha256Digest = regexp.MustCompile(`^sha256:([0-9a-f]{64})$`)
+
+// Cache keeps downloaded archives on disk by digest so they are shared by every
+// project on the machine. Refs record which digest a version of a project had.
+type Cache struct {
+	root string
+}
+
+type Key struct {
+	Repository string
+	Name       string
+	Type       string
+	Version    string
+}
+
+type Entry struct {
+	Key
+	Digest string
+	Size   int64
+	Used   time.Time
+}
+
+type ref struct {
+	Repository   string               `json:"repository"`
+	Name         string               `json:"name"`
+	Type         string               `json:"type"`
+	Version      string               `json:"version"`
+	Digest       string               `json:"digest,omitempty"`
+	Size         int64                `json:"size,omitempty"`
+	Dependencies *[]config.Dependency `json:"dependencies,omitempty"`
+}
+
+func New(root string) *Cache {
+	return &Cache{root}
+}
+
+// Default is the cache at $XDG_CACHE_HOME/idl, or ~/.cache/idl
+func Default() (*Cache, error) {
+	// relative paths are ignored as the xdg spec asks
+	cacheHome := os.Getenv("XDG_CACHE_HOME")
+	if !filepath.IsAbs(cacheHome) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to find the cache directory")
+		}
+		cacheHome = filepath.Join(home, ".cache")
+	}
+
+	return New(filepath.Join(cacheHome, "idl")), nil
+}
+
+func (c *Cache) Root() string {
+	return c.root
+}
+
+// Archive returns the cached archive of a version and its digest
+func (c *Cache) Archive(key Key) ([]byte, string, bool) {
+	r, ok := c.readRef(key)
+	if !ok || r.Digest == "" {
+		return nil, "", false
+	}
+
+	data, ok := c.ArchiveByDigest(r.Digest)
+	return data, r.Digest, ok
+}
+
+// ArchiveByDigest returns a cached archive if it still has the digest
+func (c *Cache) ArchiveByDigest(digest string) ([]byte, bool) {
+	pth, ok := c.blobPath(digest)
+	if !ok {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return nil, false
+	}
+
+	if digestOf(data) != digest {
+		os.Remove(pth)
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(pth, now, now)
+	return data, true
+}
+
+// PutArchive stores an archive and records it as the archive of the version
+func (c *Cache) PutArchive(key Key, data []byte) (string, error) {
+	digest := digestOf(data)
+	pth, _ := c.blobPath(digest)
+
+	if _, err := os.Stat(pth); err != nil {
+		err = writeFile(pth, data)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to cache archive")
+		}
+	}
+
+	err := c.updateRef(key, func(r *ref) {
+		r.Digest = digest
+		r.Size = int64(len(data))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
+
+func (c *Cache) Dependencies(key Key) ([]config.Dependency, bool) {
+	r, ok := c.readRef(key)
+	if !ok || r.Dependencies == nil {
+		return nil, false
+	}
+	return *r.Dependencies, true
+}
+
+func (c *Cache) PutDependencies(key Key, dependencies []config.Dependency) error {
+	return c.updateRef(key, func(r *ref) {
+		r.Dependencies = &dependencies
+	})
+}
+
+// Versions lists the versions of a project and type that have a cached archive
+func (c *Cache) Versions(repository string, name string, idlType string) []string {
+	dir, ok := c.refDir(Key{repository, name, idlType, ""})
+	if !ok {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	versions := []string{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		version := strings.TrimSuffix(f.Name(), ".json")
+		if _, _, ok := c.Archive(Key{repository, name, idlType, version}); ok {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// List returns every cached version, most recently used first
+func (c *Cache) List() ([]Entry, error) {
+	entries := []Entry{}
+	err := c.walkRefs(func(pth string, r ref) error {
+		if r.Digest == "" {
+			return nil
+		}
+
+		entry := Entry{Key: Key{r.Repository, r.Name, r.Type, r.Version}, Digest: r.Digest, Size: r.Size}
+		blob, _ := c.blobPath(r.Digest)
+		if info, err := os.Stat(blob); err == nil {
+			entry.Used = info.ModTime()
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Used.After(entries[j].Used)
+	})
+	return entries, nil
+}
+
+// Prune removes archives that have not been used since before the cutoff along with
+// the versions referring to them, and returns how many bytes were freed
+func (c *Cache) Prune(cutoff time.Time) (int64, error) {
+	blobs := filepath.Join(c.root, "blobs", "sha256")
+	files, err := ioutil.ReadDir(blobs)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, errors.Wrap(err, "failed to read cache")
+	}
+
+	freed := int64(0)
+	for _, f := range files {
+		if f.ModTime().Before(cutoff) {
+			err = os.Remove(filepath.Join(blobs, f.Name()))
+			if err != nil {
+				return freed, errors.Wrap(err, "failed to remove cached archive")
+			}
+			freed += f.Size()
+		}
+	}
+
+	err = c.walkRefs(func(pth string, r ref) error {
+		if r.Digest != "" {
+			blob, _ := c.blobPath(r.Digest)
+			if _, err := os.Stat(blob); err == nil {
+				return nil
+			}
+		}
+		return os.Remove(pth)
+	})
+	if err != nil {
+		return freed, errors.Wrap(err, "failed to remove cached versions")
+	}
+
+	return freed, nil
+}
+
+// Clean removes the whole cache
+func (c *Cache) Clean() error {
+	err := os.RemoveAll(c.root)
+	if err != nil {
+		return errors.Wrap(err, "failed to remove cache")
+	}
+	return nil
+}
+
+func (c *Cache) blobPath(digest string) (string, bool) {
+	match := sha256Digest.FindStringSubmatch(digest)
+	if match == nil {
+		return "", false
+	}
+	return filepath.Join(c.root, "blobs", "sha256", match[1]+".tar.gz"), true
+}
+
+// refDir is where the refs of a project and type are kept, names are checked
+// so a dependency cannot point outside of the cache
+func (c *Cache) refDir(key Key) (string, bool) {
+	refs := filepath.Join(c.root, "refs")
+	dir := filepath.Join(refs, url.PathEscape(key.Repository), filepath.FromSlash(key.Name), key.Type)
+
+	rel, err := filepath.Rel(refs, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return dir, true
+}
+
+func (c *Cache) refPath(key Key) (string, bool) {
+	dir, ok := c.refDir(key)
+	if !ok || key.Version == "" || strings.ContainsAny(key.Version, `/\`) || key.Version == ".." {
+		return "", false
+	}
+	return filepath.Join(dir, key.Version+".json"), true
+}
+
+func (c *Cache) readRef(key Key) (ref, bool) {
+	r := ref{}
+
+	pth, ok := c.refPath(key)
+	if !ok {
+		return r, false
+	}
+
+	b, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return r, false
+	}
+
+	err = json.Unmarshal(b, &r)
+	return r, err == nil
+}
+
+func (c *Cache) updateRef(key Key, update func(*ref)) error {
+	pth, ok := c.refPath(key)
+	if !ok {
+		return errors.New(fmt.Sprintf("cannot cache '%s/%s@%s'", key.Name, key.Type, key.Version))
+	}
+
+	r, _ := c.readRef(key)
+	r.Repository = key.Repository
+	r.Name = key.Name
+	r.Type = key.Type
+	r.Version = key.Version
+	update(&r)
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode cache entry")
+	}
+
+	err = writeFile(pth, b)
+	if err != nil {
+		return errors.Wrap(err, "failed to write cache entry")
+	}
+	return nil
+}
+
+func (c *Cache) walkRefs(visit func(pth string, r ref) error) error {
+	refs := filepath.Join(c.root, "refs")
+	err := filepath.Walk(refs, func(pth string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(pth, ".json") {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(pth)
+		if err != nil {
+			return err
+		}
+
+		r := ref{}
+		if json.Unmarshal(b, &r) != nil {
+			return os.Remove(pth)
+		}
+		return visit(pth, r)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to read cache")
+	}
+	return nil
+}
+
+// writeFile replaces a file in one step so concurrent pulls never see half of it
+func writeFile(pth string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(pth), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(pth), ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), pth)
+}
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
@@ -0,0 +1,141 @@
+package cache_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/syncromatics/idl-repository/pkg/cache"
+	"github.com/syncromatics/idl-repository/pkg/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cache", func() {
+	var (
+		directory string
+		c         *cache.Cache
+	)
+
+	key := func(version string) cache.Key {
+		return cache.Key{Repository: "http://repo:8080", Name: "common", Type: "proto", Version: version}
+	}
+
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "idl-cache")
+		Expect(err).To(BeNil())
+
+		c = cache.New(directory)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(directory)
+	})
+
+	It("should return cached archives by version and digest", func() {
+		digest, err := c.PutArchive(key("1.0.0"), []byte("archive"))
+		Expect(err).To(BeNil())
+		Expect(digest).To(HavePrefix("sha256:"))
+
+		data, cached, ok := c.Archive(key("1.0.0"))
+		Expect(ok).To(BeTrue())
+		Expect(cached).To(Equal(digest))
+		Expect(string(data)).To(Equal("archive"))
+
+		data, ok = c.ArchiveByDigest(digest)
+		Expect(ok).To(BeTrue())
+		Expect(string(data)).To(Equal("archive"))
+
+		_, _, ok = c.Archive(key("2.0.0"))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should share archives between versions with the same contents", func() {
+		first, _ := c.PutArchive(key("1.0.0"), []byte("archive"))
+		second, _ := c.PutArchive(cache.Key{Repository: "http://other", Name: "copy", Type: "proto", Version: "1.0.0"}, []byte("archive"))
+		Expect(second).To(Equal(first))
+
+		blobs, err := ioutil.ReadDir(filepath.Join(directory, "blobs", "sha256"))
+		Expect(err).To(BeNil())
+		Expect(blobs).To(HaveLen(1))
+	})
+
+	It("should drop archives that no longer match their digest", func() {
+		digest, _ := c.PutArchive(key("1.0.0"), []byte("archive"))
+
+		blobs, _ := filepath.Glob(filepath.Join(directory, "blobs", "sha256", "*"))
+		Expect(ioutil.WriteFile(blobs[0], []byte("tampered"), 0644)).To(BeNil())
+
+		_, ok := c.ArchiveByDigest(digest)
+		Expect(ok).To(BeFalse())
+		Expect(c.Versions("http://repo:8080", "common", "proto")).To(BeEmpty())
+	})
+
+	It("should keep dependencies alongside the archive", func() {
+		dependencies := []config.Dependency{{Name: "base", Type: "proto", Version: "^1.0.0"}}
+		Expect(c.PutDependencies(key("1.0.0"), dependencies)).To(BeNil())
+		c.PutArchive(key("1.0.0"), []byte("archive"))
+
+		cached, ok := c.Dependencies(key("1.0.0"))
+		Expect(ok).To(BeTrue())
+		Expect(cached).To(Equal(dependencies))
+
+		_, _, ok = c.Archive(key("1.0.0"))
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should list versions with an archive", func() {
+		c.PutArchive(key("1.0.0"), []byte("one"))
+		c.PutArchive(key("1.1.0"), []byte("two"))
+		c.PutDependencies(key("2.0.0"), []config.Dependency{})
+
+		Expect(c.Versions("http://repo:8080", "common", "proto")).To(ConsistOf("1.0.0", "1.1.0"))
+
+		entries, err := c.List()
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Name).To(Equal("common"))
+		Expect(entries[0].Size).To(Equal(int64(3)))
+	})
+
+	It("should refuse keys escaping the cache", func() {
+		_, err := c.PutArchive(cache.Key{Repository: "r", Name: "../../..", Type: "proto", Version: "1.0.0"}, []byte("archive"))
+		Expect(err).ToNot(BeNil())
+
+		_, err = c.PutArchive(cache.Key{Repository: "r", Name: "common", Type: "proto", Version: "../1.0.0"}, []byte("archive"))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should prune archives unused since the cutoff", func() {
+		c.PutArchive(key("1.0.0"), []byte("old"))
+		c.PutArchive(key("2.0.0"), []byte("new"))
+
+		blob, _ := filepath.Glob(filepath.Join(directory, "blobs", "sha256", "*"))
+		old := time.Now().Add(-48 * time.Hour)
+		for _, b := range blob {
+			if data, _ := ioutil.ReadFile(b); string(data) == "old" {
+				Expect(os.Chtimes(b, old, old)).To(BeNil())
+			}
+		}
+
+		freed, err := c.Prune(time.Now().Add(-24 * time.Hour))
+		Expect(err).To(BeNil())
+		Expect(freed).To(Equal(int64(3)))
+		Expect(c.Versions("http://repo:8080", "common", "proto")).To(ConsistOf("2.0.0"))
+
+		entries, _ := c.List()
+		Expect(entries).To(HaveLen(1))
+	})
+
+	It("should clean everything", func() {
+		c.PutArchive(key("1.0.0"), []byte("archive"))
+
+		Expect(c.Clean()).To(BeNil())
+
+		_, err := os.Stat(directory)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const credentialsVariable = "IDL_CREDENTIALS"
+
+type Credentials struct {
+	Repositories []RepositoryCredential `yaml:"repositories"`
+}
+
+type RepositoryCredential struct {
+	Repository string `yaml:"repository"`
+	Token      string `yaml:"token"`
+	// CA, Cert and Key are PEM files for repositories using a private CA or client certificates
+	CA   string `yaml:"ca"`
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+var (
+	loadCredentials sync.Once
+	credentials     *Credentials
+)
+
+// tokenFor finds the token to send to a repository url. $IDL_TOKEN wins over the
+// credentials file at $IDL_CREDENTIALS or $XDG_CONFIG_HOME/idl/credentials.yaml, but
+// only for the home repository so repositories named by dependencies never see it.
+func tokenFor(home string, url string) string {
+	token := os.Getenv(tokenVariable)
+	if token != "" && home != "" && onRepository(home, url) {
+		return token
+	}
+
+	return credentialFor(url).Token
+}
+
+// credentialFor finds the credentials file entry for a repository url
+func credentialFor(url string) RepositoryCredential {
+	loadCredentials.Do(func() {
+		c, err := readCredentials(credentialsLocation())
+		if err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			return
+		}
+		credentials = c
+	})
+
+	if credentials == nil {
+		return RepositoryCredential{}
+	}
+
+	// the longest matching repository is the most specific
+	best := RepositoryCredential{}
+	for _, c := range credentials.Repositories {
+		repository := strings.TrimSuffix(c.Repository, "/")
+		if onRepository(c.Repository, url) && len(repository) > len(strings.TrimSuffix(best.Repository, "/")) {
+			best = c
+		}
+	}
+	return best
+}
+
+// onRepository checks that url is on the repository, the trailing slash keeps
+// https://example.com from matching https://example.com.evil.org
+func onRepository(repository string, url string) bool {
+	return strings.HasPrefix(url, strings.TrimSuffix(repository, "/")+"/")
+}
+
+func credentialsLocation() string {
+	location := os.Getenv(credentialsVariable)
+	if location != "" {
+		return location
+	}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(configHome, "idl", "credentials.yaml")
+}
+
+func readCredentials(location string) (*Credentials, error) {
+	if location == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(location)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open credentials file")
+	}
+	defer f.Close()
+
+	c := &Credentials{}
+	err = yaml.NewDecoder(f).Decode(c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read credentials file '%s'", location)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/syncromatics/idl-repository/pkg/config"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/pkg/errors"
+)
+
+// deprecation is what the repository answers for deprecated versions, scope tells whether
+// the version itself, its type or its whole project was deprecated
+type deprecation struct {
+	Scope       string `json:"scope"`
+	Message     string `json:"message"`
+	Replacement string `json:"replacement"`
+}
+
+// checkDeprecation asks if a dependency is deprecated. Archives are cached for good so this
+// is asked on every pull, a repository that cannot be asked is no reason to fail it.
+func checkDeprecation(home string, dependency config.LockedDependency) *deprecation {
+	url := fmt.Sprintf("%s/v1/projects/%s/types/%s/versions/%s/deprecation",
+		dependency.Repository,
+		dependency.Name,
+		dependency.Type,
+		dependency.Version)
+
+	resp, err := get(home, url)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var marker *deprecation
+	if err := json.NewDecoder(resp.Body).Decode(&marker); err != nil || marker == nil || marker.Message == "" {
+		return nil
+	}
+	return marker
+}
+
+func (d *deprecation) describe(dependency config.LockedDependency) string {
+	message := fmt.Sprintf("dependency '%s' is deprecated", describe(dependency))
+	if d.Scope == "project" || d.Scope == "type" {
+		message += fmt.Sprintf(" along with its %s", d.Scope)
+	}
+
+	message += ": " + d.Message
+	if d.Replacement != "" {
+		message += fmt.Sprintf(", use '%s' instead", d.Replacement)
+	}
+	return message
+}
+
+type DeprecateOptions struct {
+	Configuration *config.Configuration
+	// Version deprecates one version of every provided type, or only of Type when set.
+	// Without a version Type deprecates the whole type and without either the whole project is.
+	Version     *semver.Version
+	Type        string
+	Message     string
+	Replacement string
+}
+
+// Deprecate marks the project, a type or a version so pulls warn about it
+func Deprecate(options DeprecateOptions) error {
+	if options.Message == "" {
+		return errors.New("a deprecation needs a message")
+	}
+
+	b, err := json.Marshal(struct {
+		Message     string `json:"message"`
+		Replacement string `json:"replacement,omitempty"`
+	}{options.Message, options.Replacement})
+	if err != nil {
+		return errors.Wrap(err, "failed encoding deprecation")
+	}
+
+	return eachDeprecation(options, func(url string) error {
+		resp, err := put(options.Configuration.Repository, url, "application/json", bytes.NewReader(b))
+		if err != nil {
+			return errors.Wrap(err, "failed deprecating")
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return errors.Wrap(responseError(resp), "deprecating failed")
+		}
+		return nil
+	})
+}
+
+// Undeprecate removes a deprecation set with the same options
+func Undeprecate(options DeprecateOptions) error {
+	return eachDeprecation(options, func(url string) error {
+		resp, err := del(options.Configuration.Repository, url)
+		if err != nil {
+			return errors.Wrap(err, "failed removing deprecation")
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return errors.Wrap(responseError(resp), "removing deprecation failed")
+		}
+		return nil
+	})
+}
+
+func eachDeprecation(options DeprecateOptions, change func(url string) error) error {
+	for _, url := range deprecationURLs(options) {
+		err := change(url)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deprecationURLs(options DeprecateOptions) []string {
+	project := fmt.Sprintf("%s/v1/projects/%s", options.Configuration.Repository, options.Configuration.Name)
+
+	if options.Version == nil {
+		if options.Type == "" {
+			return []string{project + "/deprecation"}
+		}
+		return []string{fmt.Sprintf("%s/types/%s/deprecation", project, options.Type)}
+	}
+
+	types := []string{options.Type}
+	if options.Type == "" {
+		types = []string{}
+		for _, provider := range options.Configuration.Provides {
+			types = append(types, provider.Type)
+		}
+	}
+
+	urls := []string{}
+	for _, idlType := range types {
+		urls = append(urls, fmt.Sprintf("%s/types/%s/versions/%s/deprecation", project, idlType, options.Version.String()))
+	}
+	return urls
+}
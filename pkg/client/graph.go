@@ -0,0 +1,365 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/syncromatics/idl-repository/pkg/cache"
+	"github.com/syncromatics/idl-repository/pkg/config"
+	"github.com/syncromatics/idl-repository/pkg/constraint"
+
+	"github.com/pkg/errors"
+)
+
+const maxResolveIterations = 100
+
+type requirement struct {
+	dependency config.Dependency
+	from       string
+}
+
+// resolver walks the dependency graph starting at the configuration's dependencies
+// and picks a single version for every project and type that satisfies all requirements on it.
+type resolver struct {
+	configuration *config.Configuration
+	lock          *config.Lock
+	update        bool
+	cache         *cache.Cache
+	offline       bool
+
+	versions     map[string][]string
+	dependencies map[string][]config.Dependency
+}
+
+func newResolver(configuration *config.Configuration, lock *config.Lock, update bool, c *cache.Cache, offline bool) *resolver {
+	return &resolver{
+		configuration: configuration,
+		lock:          lock,
+		update:        update,
+		cache:         c,
+		offline:       offline,
+		versions:      map[string][]string{},
+		dependencies:  map[string][]config.Dependency{},
+	}
+}
+
+func (r *resolver) resolve() ([]config.LockedDependency, error) {
+	chosen := map[string]config.LockedDependency{}
+
+	for i := 0; i < maxResolveIterations; i++ {
+		order, requirements, err := r.walk(chosen)
+		if err != nil {
+			return nil, err
+		}
+
+		next := map[string]config.LockedDependency{}
+		for _, key := range order {
+			locked, err := r.choose(requirements[key])
+			if err != nil {
+				return nil, err
+			}
+			next[key] = locked
+		}
+
+		if sameChoices(chosen, next) {
+			err = r.checkCycles(order, chosen)
+			if err != nil {
+				return nil, err
+			}
+
+			resolved := []config.LockedDependency{}
+			for _, key := range order {
+				resolved = append(resolved, chosen[key])
+			}
+			return resolved, nil
+		}
+
+		chosen = next
+	}
+
+	return nil, errors.New("dependency versions did not settle, check for conflicting requirements")
+}
+
+// walk collects the requirements reachable from the configuration given the versions chosen so far
+func (r *resolver) walk(chosen map[string]config.LockedDependency) ([]string, map[string][]requirement, error) {
+	queue := []requirement{}
+	for _, dependency := range r.configuration.Dependencies {
+		dependency.Repository = r.configuration.ResolveRepository(dependency)
+		queue = append(queue, requirement{dependency, "idl.yaml"})
+	}
+
+	order := []string{}
+	requirements := map[string][]requirement{}
+	for len(queue) > 0 {
+		req := queue[0]
+		queue = queue[1:]
+
+		if req.dependency.Name == r.configuration.Name {
+			return nil, nil, errors.New(fmt.Sprintf("dependency cycle detected: %s requires '%s' which is this project", req.from, req.dependency.Name))
+		}
+
+		key := dependencyKey(req.dependency.Name, req.dependency.Type)
+		_, seen := requirements[key]
+		requirements[key] = append(requirements[key], req)
+		if seen {
+			continue
+		}
+		order = append(order, key)
+
+		locked, ok := chosen[key]
+		if !ok {
+			continue
+		}
+
+		dependencies, err := r.dependenciesOf(locked)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, dependency := range dependencies {
+			if dependency.Repository == "" {
+				dependency.Repository = locked.Repository
+			}
+			queue = append(queue, requirement{dependency, describe(locked)})
+		}
+	}
+
+	return order, requirements, nil
+}
+
+func (r *resolver) choose(requirements []requirement) (config.LockedDependency, error) {
+	first := requirements[0].dependency
+	locked := config.LockedDependency{
+		Name:       first.Name,
+		Type:       first.Type,
+		Repository: first.Repository,
+	}
+
+	previous, ok := r.lock.Find(first.Name, first.Type)
+	if ok && !r.update && previous.Repository == locked.Repository && satisfiesAll(requirements, previous.Version) {
+		return previous, nil
+	}
+
+	for _, req := range requirements {
+		if constraint.IsExact(req.dependency.Version) {
+			if !satisfiesAll(requirements, req.dependency.Version) {
+				return locked, conflict(requirements)
+			}
+			locked.Version = req.dependency.Version
+			return locked, nil
+		}
+	}
+
+	versions, err := r.versionsOf(locked)
+	if err != nil {
+		return locked, err
+	}
+
+	wanted, err := constraint.Parse(first.Version)
+	if err != nil {
+		return locked, err
+	}
+
+	// the highest version of the first requirement that all the others accept too
+	candidates := []string{}
+	for _, v := range versions {
+		if satisfiesAll(requirements[1:], v) {
+			candidates = append(candidates, v)
+		}
+	}
+
+	version, ok := wanted.Highest(candidates)
+	if !ok {
+		return locked, conflict(requirements)
+	}
+
+	locked.Version = version
+	return locked, nil
+}
+
+func (r *resolver) checkCycles(order []string, chosen map[string]config.LockedDependency) error {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+
+	state := map[string]int{}
+	path := []string{}
+
+	var visit func(key string) error
+	visit = func(key string) error {
+		switch state[key] {
+		case visiting:
+			for i, p := range path {
+				if p == key {
+					cycle := []string{}
+					for _, k := range append(path[i:], key) {
+						cycle = append(cycle, describe(chosen[k]))
+					}
+					return errors.New(fmt.Sprintf("dependency cycle detected: %s", strings.Join(cycle, " -> ")))
+				}
+			}
+		case done:
+			return nil
+		}
+
+		state[key] = visiting
+		path = append(path, key)
+
+		dependencies, err := r.dependenciesOf(chosen[key])
+		if err != nil {
+			return err
+		}
+
+		for _, dependency := range dependencies {
+			err = visit(dependencyKey(dependency.Name, dependency.Type))
+			if err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[key] = done
+		return nil
+	}
+
+	for _, key := range order {
+		err := visit(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) versionsOf(locked config.LockedDependency) ([]string, error) {
+	key := locked.Repository + "|" + dependencyKey(locked.Name, locked.Type)
+	versions, ok := r.versions[key]
+	if ok {
+		return versions, nil
+	}
+
+	if r.offline {
+		versions = r.cache.Versions(locked.Repository, locked.Name, locked.Type)
+		if len(versions) == 0 {
+			return nil, errors.New(fmt.Sprintf("no versions of dependency '%s' with type '%s' are cached", locked.Name, locked.Type))
+		}
+		r.versions[key] = versions
+		return versions, nil
+	}
+
+	versions, err := listVersions(r.configuration.Repository, locked.Repository, locked.Name, locked.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	r.versions[key] = versions
+	return versions, nil
+}
+
+func (r *resolver) dependenciesOf(locked config.LockedDependency) ([]config.Dependency, error) {
+	key := locked.Repository + "|" + describe(locked)
+	dependencies, ok := r.dependencies[key]
+	if ok {
+		return dependencies, nil
+	}
+
+	// the dependencies of a published version never change
+	cacheKey := cacheKeyOf(locked)
+	if r.cache != nil {
+		dependencies, ok = r.cache.Dependencies(cacheKey)
+		if ok {
+			r.dependencies[key] = dependencies
+			return dependencies, nil
+		}
+	}
+
+	if r.offline {
+		return nil, errors.New(fmt.Sprintf("dependencies of '%s' are not cached", describe(locked)))
+	}
+
+	path := fmt.Sprintf("%s/v1/projects/%s/types/%s/versions/%s/dependencies",
+		locked.Repository,
+		locked.Name,
+		locked.Type,
+		locked.Version)
+
+	resp, err := get(r.configuration.Repository, path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting dependencies")
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(resp.Body).Decode(&dependencies)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed decoding dependencies")
+		}
+	case http.StatusNotFound:
+		return nil, errors.New(fmt.Sprintf("dependency '%s' does not exist", describe(locked)))
+	default:
+		return nil, errors.Wrapf(responseError(resp), "failed getting dependencies of '%s'", describe(locked))
+	}
+
+	if r.cache != nil {
+		// a cache that cannot be written only costs the next pull a download
+		r.cache.PutDependencies(cacheKey, dependencies)
+	}
+
+	r.dependencies[key] = dependencies
+	return dependencies, nil
+}
+
+func satisfiesAll(requirements []requirement, version string) bool {
+	for _, req := range requirements {
+		if !satisfies(req.dependency.Version, version) {
+			return false
+		}
+	}
+	return true
+}
+
+func conflict(requirements []requirement) error {
+	first := requirements[0].dependency
+	wants := []string{}
+	for _, req := range requirements {
+		wants = append(wants, fmt.Sprintf("'%s' from %s", req.dependency.Version, req.from))
+	}
+
+	return errors.New(fmt.Sprintf("no version of dependency '%s' with type '%s' satisfies %s",
+		first.Name, first.Type, strings.Join(wants, ", ")))
+}
+
+func sameChoices(a map[string]config.LockedDependency, b map[string]config.LockedDependency) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, locked := range a {
+		if other, ok := b[key]; !ok || other != locked {
+			return false
+		}
+	}
+	return true
+}
+
+func dependencyKey(name string, idlType string) string {
+	return name + "/" + idlType
+}
+
+func cacheKeyOf(locked config.LockedDependency) cache.Key {
+	return cache.Key{
+		Repository: locked.Repository,
+		Name:       locked.Name,
+		Type:       locked.Type,
+		Version:    locked.Version,
+	}
+}
+
+func describe(locked config.LockedDependency) string {
+	return fmt.Sprintf("%s/%s@%s", locked.Name, locked.Type, locked.Version)
+}
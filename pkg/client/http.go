@@ -0,0 +1,105 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const tokenVariable = "IDL_TOKEN"
+
+// home is the repository of idl.yaml, the only repository $IDL_TOKEN and the TLS files in the
+// environment are used for. Repositories of dependencies only get what the credentials file has for them.
+func get(home string, url string) (*http.Response, error) {
+	return send(home, http.MethodGet, url, "", nil)
+}
+
+func post(home string, url string, contentType string, body io.Reader) (*http.Response, error) {
+	return send(home, http.MethodPost, url, contentType, body)
+}
+
+func put(home string, url string, contentType string, body io.Reader) (*http.Response, error) {
+	return send(home, http.MethodPut, url, contentType, body)
+}
+
+func del(home string, url string) (*http.Response, error) {
+	return send(home, http.MethodDelete, url, "", nil)
+}
+
+func send(home string, method string, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed creating request")
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	return do(home, req)
+}
+
+// do sends a request built by the caller with the token for its repository
+func do(home string, req *http.Request) (*http.Response, error) {
+	token := tokenFor(home, req.URL.String())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client, err := clientFor(home, req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Do(req)
+}
+
+// responseError describes a failed response using the message the repository sent back.
+// Errors are {code, message, request_id, details}, older repositories send a plain message
+// or put issues next to the message.
+func responseError(resp *http.Response) error {
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil || len(b) == 0 {
+		return errors.New(fmt.Sprintf("status code %d is not OK", resp.StatusCode))
+	}
+
+	var message string
+	err = json.Unmarshal(b, &message)
+	if err == nil {
+		return errors.New(message)
+	}
+
+	type issue struct {
+		File    string `json:"file"`
+		Message string `json:"message"`
+	}
+	detailed := struct {
+		Code      string  `json:"code"`
+		Message   string  `json:"message"`
+		RequestID string  `json:"request_id"`
+		Issues    []issue `json:"issues"`
+		Details   struct {
+			Issues []issue `json:"issues"`
+		} `json:"details"`
+	}{}
+	err = json.Unmarshal(b, &detailed)
+	if err != nil || detailed.Message == "" {
+		return errors.New(strings.TrimSpace(string(b)))
+	}
+
+	// only the request id helps with failures inside the repository
+	lines := []string{detailed.Message}
+	if resp.StatusCode >= 500 && detailed.RequestID != "" {
+		lines[0] = fmt.Sprintf("%s (request id %s)", detailed.Message, detailed.RequestID)
+	}
+	for _, issue := range append(detailed.Issues, detailed.Details.Issues...) {
+		lines = append(lines, fmt.Sprintf("  %s: %s", issue.File, issue.Message))
+	}
+
+	return errors.New(strings.Join(lines, "\n"))
+}
@@ -2,14 +2,19 @@ package client
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 
+	"github.com/syncromatics/idl-repository/pkg/cache"
 	"github.com/syncromatics/idl-repository/pkg/config"
 
 	"github.com/pkg/errors"
@@ -17,73 +22,272 @@ import (
 
 type PullOptions struct {
 	Configuration *config.Configuration
+	Lock          *config.Lock
+	Update        bool
+	// MaxSize caps the downloaded and uncompressed size of each dependency in bytes, zero uses DefaultMaxSize
+	MaxSize int64
+	// Jobs is how many dependencies are downloaded at once, zero uses DefaultJobs
+	Jobs int
+	// Cache keeps downloaded archives between pulls, nil disables it
+	Cache *cache.Cache
+	// Offline resolves and unpacks dependencies from the cache only
+	Offline bool
+	// Strict fails dependencies that are deprecated instead of warning about them
+	Strict bool
+	// Warn receives a message for every deprecated dependency, nil ignores them
+	Warn func(message string)
 }
 
-func Pull(options PullOptions) error {
+// defaults for options left at zero
+const (
+	DefaultMaxSize = 256 << 20
+	DefaultJobs    = 4
+)
+
+func Pull(options PullOptions) (*config.Lock, error) {
 	if len(options.Configuration.Dependencies) < 1 {
-		return errors.New("nothing to pull")
+		return nil, errors.New("nothing to pull")
 	}
 
 	err := options.Configuration.Validate()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, dependency := range options.Configuration.Dependencies {
-		path := fmt.Sprintf("%s/v1/projects/%s/types/%s/versions/%s/data.tar.gz",
-			options.Configuration.ResolveRepository(dependency),
-			dependency.Name,
-			dependency.Type,
-			dependency.Version)
+	if options.Offline && options.Cache == nil {
+		return nil, errors.New("pulling offline needs a cache")
+	}
 
-		resp, err := http.Get(path)
-		if err != nil {
-			return errors.Wrap(err, "failed getting dependency")
+	resolved, err := newResolver(options.Configuration, options.Lock, options.Update, options.Cache, options.Offline).resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	maxSize := options.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	jobs := options.Jobs
+	if jobs < 1 {
+		jobs = DefaultJobs
+	}
+
+	// every dependency is attempted so all failures can be reported together
+	errs := make([]error, len(resolved))
+	deprecations := make([]*deprecation, len(resolved))
+	slots := make(chan struct{}, jobs)
+	wg := sync.WaitGroup{}
+	for i := range resolved {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			resolved[i].Digest, deprecations[i], errs[i] = fetch(options, resolved[i], maxSize)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, marker := range deprecations {
+		if marker != nil && errs[i] == nil && options.Warn != nil {
+			options.Warn(marker.describe(resolved[i]))
 		}
+	}
+
+	err = aggregate(errs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config.Lock{Dependencies: resolved}, nil
+}
+
+// fetch downloads, verifies and unpacks a dependency and returns its digest and deprecation
+func fetch(options PullOptions, locked config.LockedDependency, maxSize int64) (string, *deprecation, error) {
+	data, err := cachedOrDownload(options, locked, maxSize)
+	if err != nil {
+		return "", nil, err
+	}
+
+	digest := digestOf(data)
+	if locked.Digest != "" && locked.Digest != digest {
+		return "", nil, errors.New(fmt.Sprintf("digest of dependency '%s' with type '%s' version '%s' is '%s' but the lock file expects '%s'",
+			locked.Name, locked.Type, locked.Version, digest, locked.Digest))
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			return errors.New(fmt.Sprintf("status code %d is not OK", resp.StatusCode))
+	// archives never change but deprecations can, so they are asked for on their own
+	var marker *deprecation
+	if !options.Offline {
+		marker = checkDeprecation(options.Configuration.Repository, locked)
+	}
+	if marker != nil && options.Strict {
+		return "", nil, errors.New(marker.describe(locked))
+	}
+
+	err = unPackDependency(options.Configuration, locked, ioutil.NopCloser(bytes.NewReader(data)), maxSize)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return digest, marker, nil
+}
+
+// cachedOrDownload prefers the archive the lock file pins, then whatever the cache has for the version
+func cachedOrDownload(options PullOptions, locked config.LockedDependency, maxSize int64) ([]byte, error) {
+	if options.Cache != nil {
+		var data []byte
+		var ok bool
+		if locked.Digest != "" {
+			data, ok = options.Cache.ArchiveByDigest(locked.Digest)
+		} else {
+			data, _, ok = options.Cache.Archive(cacheKeyOf(locked))
+		}
+
+		if ok {
+			return data, nil
 		}
+	}
+
+	if options.Offline {
+		return nil, errors.New(fmt.Sprintf("dependency '%s' is not cached", describe(locked)))
+	}
 
-		err = unPackDependency(options.Configuration, dependency, resp.Body)
+	data, err := download(options.Configuration.Repository, locked, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Cache != nil {
+		// a cache that cannot be written only costs the next pull a download
+		options.Cache.PutArchive(cacheKeyOf(locked), data)
+	}
+
+	return data, nil
+}
+
+// aggregate combines the errors of dependencies pulled at the same time
+func aggregate(errs []error) error {
+	messages := []string{}
+	for _, err := range errs {
 		if err != nil {
-			return err
+			messages = append(messages, err.Error())
 		}
 	}
-	return nil
+
+	switch len(messages) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New(messages[0])
+	}
+
+	return errors.New(fmt.Sprintf("failed to pull %d dependencies:\n  %s", len(messages), strings.Join(messages, "\n  ")))
 }
 
-func unPackDependency(configuration *config.Configuration, dependency config.Dependency, file io.ReadCloser) error {
+func archiveURL(dependency config.LockedDependency) string {
+	return fmt.Sprintf("%s/v1/projects/%s/types/%s/versions/%s/data.tar.gz",
+		dependency.Repository,
+		dependency.Name,
+		dependency.Type,
+		dependency.Version)
+}
+
+// download reads the archive of a dependency, refusing archives larger than maxSize
+func download(home string, dependency config.LockedDependency, maxSize int64) ([]byte, error) {
+	resp, err := get(home, archiveURL(dependency))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting dependency")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Wrapf(responseError(resp), "failed getting dependency '%s'", describe(dependency))
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading dependency")
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, errors.New(fmt.Sprintf("archive of dependency '%s' is larger than the limit of %d bytes", describe(dependency), maxSize))
+	}
+
+	return data, nil
+}
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func unPackDependency(configuration *config.Configuration, dependency config.LockedDependency, file io.ReadCloser, maxSize int64) error {
 	defer file.Close()
 
 	var newMode os.FileMode
 	dirStat, err := os.Stat(configuration.IdlDirectory)
 	if err == nil {
-		 newMode = dirStat.Mode()
+		newMode = dirStat.Mode()
 	} else {
 		newMode = os.ModePerm
 	}
 
-	pth := path.Join(configuration.IdlDirectory, dependency.Name, dependency.Type)
+	pth := filepath.Join(configuration.IdlDirectory, dependency.Name, dependency.Type)
+	if !within(configuration.IdlDirectory, pth) {
+		return errors.New(fmt.Sprintf("dependency '%s' would be written outside of the idl directory", describe(dependency)))
+	}
+
+	err = os.MkdirAll(filepath.Dir(pth), newMode)
+	if err != nil {
+		return errors.Wrap(err, "failed to create directories")
+	}
+
+	// the archive is unpacked next to the dependency so a bad archive leaves the previous files in place
+	unpacked, err := ioutil.TempDir(filepath.Dir(pth), "."+dependency.Type+"-")
+	if err != nil {
+		return errors.Wrap(err, "failed to create directories")
+	}
+	defer os.RemoveAll(unpacked)
+
+	err = os.Chmod(unpacked, newMode.Perm())
+	if err != nil {
+		return errors.Wrap(err, "failed to create directories")
+	}
+
+	err = unTar(dependency, file, unpacked, newMode, maxSize)
+	if err != nil {
+		return err
+	}
 
 	err = os.RemoveAll(pth)
 	if err != nil {
 		return errors.Wrap(err, "failed to clean path")
 	}
 
-	err = os.MkdirAll(pth, newMode)
+	err = os.Rename(unpacked, pth)
 	if err != nil {
-		return errors.Wrap(err, "failed to create directories")
+		return errors.Wrap(err, "failed to move dependency into place")
 	}
 
+	return nil
+}
+
+// unTar writes the files of an archive into pth
+func unTar(dependency config.LockedDependency, file io.Reader, pth string, newMode os.FileMode, maxSize int64) error {
 	gzr, err := gzip.NewReader(file)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "failed to read dependency '%s'", describe(dependency))
 	}
 	defer gzr.Close()
 
 	tr := tar.NewReader(gzr)
 
+	rejected := func(header *tar.Header, reason string) error {
+		return errors.New(fmt.Sprintf("refusing entry '%s' of dependency '%s': %s", header.Name, describe(dependency), reason))
+	}
+
+	remaining := maxSize
 	for {
 		header, err := tr.Next()
 
@@ -95,7 +299,7 @@ func unPackDependency(configuration *config.Configuration, dependency config.Dep
 
 		// return any other error
 		case err != nil:
-			return err
+			return errors.Wrapf(err, "failed to read dependency '%s'", describe(dependency))
 
 		// if the header is nil, just skip it (not sure how this happens)
 		case header == nil:
@@ -104,10 +308,9 @@ func unPackDependency(configuration *config.Configuration, dependency config.Dep
 
 		// the target location where the dir/file should be created
 		target := filepath.Join(pth, header.Name)
-
-		// the following switch could also be done using fi.Mode(), not sure if there
-		// a benefit of using one vs. the other.
-		// fi := header.FileInfo()
+		if !within(pth, target) {
+			return rejected(header, "it escapes the dependency directory")
+		}
 
 		// check the file type
 		switch header.Typeflag {
@@ -121,25 +324,48 @@ func unPackDependency(configuration *config.Configuration, dependency config.Dep
 			}
 
 		// if it's a file create it
-		case tar.TypeReg:
+		case tar.TypeReg, tar.TypeRegA:
+			if header.Size > remaining {
+				return rejected(header, fmt.Sprintf("the dependency is larger than the limit of %d bytes", maxSize))
+			}
+			remaining -= header.Size
+
 			parent := filepath.Dir(target)
 			if err := os.MkdirAll(parent, newMode); err != nil {
 				return err
 			}
 
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode).Perm())
 			if err != nil {
 				return err
 			}
 
-			// copy over contents
+			// copy over contents, the tar reader stops at the size in the header
 			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
 				return err
 			}
 
 			// manually close here after each file operation; defering would cause each file close
 			// to wait until all operations have completed.
 			f.Close()
+
+		case tar.TypeXGlobalHeader:
+
+		case tar.TypeSymlink, tar.TypeLink:
+			return rejected(header, fmt.Sprintf("links are not allowed, it points to '%s'", header.Linkname))
+
+		default:
+			return rejected(header, fmt.Sprintf("unsupported entry type '%c'", header.Typeflag))
 		}
 	}
 }
+
+// within checks that target is inside of directory once both are cleaned
+func within(directory string, target string) bool {
+	rel, err := filepath.Rel(directory, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,563 @@
+package client_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/syncromatics/idl-repository/pkg/cache"
+	"github.com/syncromatics/idl-repository/pkg/client"
+	"github.com/syncromatics/idl-repository/pkg/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeVersion struct {
+	dependencies []config.Dependency
+	files        map[string]string
+	// raw is served instead of an archive of files
+	raw []byte
+	// deprecation is sent in the deprecation header with the archive
+	deprecation string
+}
+
+// fakeRepository serves projects as "project/type" -> version -> contents
+func fakeRepository(projects map[string]map[string]fakeVersion) *httptest.Server {
+	return httptest.NewServer(fakeHandler(projects))
+}
+
+func fakeHandler(projects map[string]map[string]fakeVersion) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pth := strings.TrimPrefix(r.URL.Path, "/v1/projects/")
+		parts := strings.Split(pth, "/")
+		if len(parts) < 4 || parts[1] != "types" || parts[3] != "versions" {
+			w.WriteHeader(404)
+			return
+		}
+
+		versions, ok := projects[parts[0]+"/"+parts[2]]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+
+		if len(parts) == 4 {
+			listed := []map[string]string{}
+			for name := range versions {
+				listed = append(listed, map[string]string{"version": name})
+			}
+			json.NewEncoder(w).Encode(listed)
+			return
+		}
+
+		version, ok := versions[parts[4]]
+		if !ok || len(parts) != 6 {
+			w.WriteHeader(404)
+			return
+		}
+
+		switch parts[5] {
+		case "dependencies":
+			dependencies := version.dependencies
+			if dependencies == nil {
+				dependencies = []config.Dependency{}
+			}
+			json.NewEncoder(w).Encode(dependencies)
+		case "deprecation":
+			if version.deprecation == "" {
+				w.Write([]byte("null"))
+				return
+			}
+			w.Write([]byte(version.deprecation))
+		case "data.tar.gz":
+			if version.raw != nil {
+				w.Write(version.raw)
+				return
+			}
+			w.Write(archive(version.files))
+		default:
+			w.WriteHeader(404)
+		}
+	})
+}
+
+func archive(files map[string]string) []byte {
+	buf := new(bytes.Buffer)
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gzw.Close()
+	return buf.Bytes()
+}
+
+func rawArchive(headers ...tar.Header) []byte {
+	buf := new(bytes.Buffer)
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+	for _, header := range headers {
+		header.Mode = 0644
+		tw.WriteHeader(&header)
+		tw.Write(bytes.Repeat([]byte("x"), int(header.Size)))
+	}
+	tw.Close()
+	gzw.Close()
+	return buf.Bytes()
+}
+
+var _ = Describe("Pull", func() {
+	var (
+		server    *httptest.Server
+		directory string
+	)
+
+	pull := func(dependencies ...config.Dependency) (*config.Lock, error) {
+		return client.Pull(client.PullOptions{
+			Configuration: &config.Configuration{
+				Name:         "consumer",
+				Repository:   server.URL,
+				IdlDirectory: directory,
+				Dependencies: dependencies,
+			},
+		})
+	}
+
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "idl-pull")
+		Expect(err).To(BeNil())
+
+		server = fakeRepository(map[string]map[string]fakeVersion{
+			"service/proto": {
+				"1.0.0": {
+					dependencies: []config.Dependency{{Name: "common", Type: "proto", Version: "^1.1.0"}},
+					files:        map[string]string{"service.proto": "v1"},
+				},
+			},
+			"other/proto": {
+				"2.0.0": {
+					dependencies: []config.Dependency{{Name: "common", Type: "proto", Version: "~1.2.0"}},
+					files:        map[string]string{"other.proto": "v2"},
+				},
+			},
+			"common/proto": {
+				"1.0.0": {files: map[string]string{"common.proto": "1.0.0"}},
+				"1.2.4": {files: map[string]string{"common.proto": "1.2.4"}},
+				"1.3.0": {files: map[string]string{"common.proto": "1.3.0"}},
+			},
+			"shared/proto": {
+				"1.1.0":        {files: map[string]string{"shared.proto": "1.1.0"}},
+				"1.4.0":        {files: map[string]string{"shared.proto": "1.4.0"}},
+				"1.5.0-beta.1": {files: map[string]string{"shared.proto": "1.5.0-beta.1"}},
+				"2.0.0":        {files: map[string]string{"shared.proto": "2.0.0"}},
+			},
+			"client/proto": {
+				"1.0.0": {dependencies: []config.Dependency{{Name: "shared", Type: "proto", Version: ">=1.2.0"}}},
+			},
+			"cycle-a/proto": {
+				"1.0.0": {dependencies: []config.Dependency{{Name: "cycle-b", Type: "proto", Version: "1.0.0"}}},
+			},
+			"cycle-b/proto": {
+				"1.0.0": {dependencies: []config.Dependency{{Name: "cycle-a", Type: "proto", Version: "^1.0.0"}}},
+			},
+			"evil/proto": {
+				"1.0.0": {raw: rawArchive(tar.Header{Name: "../../../escaped.proto", Typeflag: tar.TypeReg, Size: 1})},
+				"2.0.0": {raw: rawArchive(tar.Header{Name: "link.proto", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})},
+				"3.0.0": {raw: rawArchive(tar.Header{Name: "huge.proto", Typeflag: tar.TypeReg, Size: 2048})},
+				"4.0.0": {files: map[string]string{"evil.proto": "4.0.0"}},
+				"5.0.0": {raw: bytes.Repeat([]byte("x"), 4096)},
+			},
+			"broken/proto": {
+				"1.0.0": {raw: []byte("not an archive")},
+			},
+			"legacy/proto": {
+				"1.0.0": {files: map[string]string{"/legacy.proto": "absolute names stay inside"}},
+			},
+			"old/proto": {
+				"1.0.0": {
+					files:       map[string]string{"old.proto": "1.0.0"},
+					deprecation: `{"scope": "project", "message": "no longer maintained", "replacement": "example/v2"}`,
+				},
+			},
+		})
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(directory)
+	})
+
+	It("should pull transitive dependencies", func() {
+		lock, err := pull(config.Dependency{Name: "service", Type: "proto", Version: "^1.0.0"})
+		Expect(err).To(BeNil())
+		Expect(lock.Dependencies).To(HaveLen(2))
+
+		common, ok := lock.Find("common", "proto")
+		Expect(ok).To(BeTrue())
+		Expect(common.Version).To(Equal("1.3.0"))
+
+		b, err := ioutil.ReadFile(filepath.Join(directory, "common", "proto", "common.proto"))
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal("1.3.0"))
+	})
+
+	It("should pick a version satisfying every requirement", func() {
+		lock, err := pull(
+			config.Dependency{Name: "service", Type: "proto", Version: "^1.0.0"},
+			config.Dependency{Name: "other", Type: "proto", Version: "2.0.0"})
+		Expect(err).To(BeNil())
+
+		common, _ := lock.Find("common", "proto")
+		Expect(common.Version).To(Equal("1.2.4"))
+	})
+
+	It("should pick the highest release every requirement accepts", func() {
+		lock, err := pull(
+			config.Dependency{Name: "shared", Type: "proto", Version: "^1.0.0"},
+			config.Dependency{Name: "client", Type: "proto", Version: "1.0.0"})
+		Expect(err).To(BeNil())
+
+		// 1.1.0 is too old for client, 1.5.0-beta.1 is a pre-release and only client accepts 2.0.0
+		shared, _ := lock.Find("shared", "proto")
+		Expect(shared.Version).To(Equal("1.4.0"))
+	})
+
+	It("should report conflicting requirements", func() {
+		_, err := pull(
+			config.Dependency{Name: "service", Type: "proto", Version: "^1.0.0"},
+			config.Dependency{Name: "common", Type: "proto", Version: "1.0.0"})
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("no version of dependency 'common' with type 'proto' satisfies"))
+		Expect(err.Error()).To(ContainSubstring("'^1.1.0' from service/proto@1.0.0"))
+	})
+
+	It("should report cycles", func() {
+		_, err := pull(config.Dependency{Name: "cycle-a", Type: "proto", Version: "1.0.0"})
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(Equal("dependency cycle detected: cycle-a/proto@1.0.0 -> cycle-b/proto@1.0.0 -> cycle-a/proto@1.0.0"))
+	})
+
+	It("should refuse entries escaping the dependency directory", func() {
+		_, err := pull(config.Dependency{Name: "evil", Type: "proto", Version: "1.0.0"})
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(Equal("refusing entry '../../../escaped.proto' of dependency 'evil/proto@1.0.0': it escapes the dependency directory"))
+
+		_, err = os.Stat(filepath.Join(directory, "..", "escaped.proto"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("should refuse links", func() {
+		_, err := pull(config.Dependency{Name: "evil", Type: "proto", Version: "2.0.0"})
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(Equal("refusing entry 'link.proto' of dependency 'evil/proto@2.0.0': links are not allowed, it points to '/etc/passwd'"))
+	})
+
+	It("should cap the unpacked size", func() {
+		_, err := client.Pull(client.PullOptions{
+			Configuration: &config.Configuration{
+				Name:         "consumer",
+				Repository:   server.URL,
+				IdlDirectory: directory,
+				Dependencies: []config.Dependency{{Name: "evil", Type: "proto", Version: "3.0.0"}},
+			},
+			MaxSize: 1024,
+		})
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(Equal("refusing entry 'huge.proto' of dependency 'evil/proto@3.0.0': the dependency is larger than the limit of 1024 bytes"))
+	})
+
+	It("should stop downloading archives larger than the cap", func() {
+		_, err := client.Pull(client.PullOptions{
+			Configuration: &config.Configuration{
+				Name:         "consumer",
+				Repository:   server.URL,
+				IdlDirectory: directory,
+				Dependencies: []config.Dependency{{Name: "evil", Type: "proto", Version: "5.0.0"}},
+			},
+			MaxSize: 1024,
+		})
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(Equal("archive of dependency 'evil/proto@5.0.0' is larger than the limit of 1024 bytes"))
+	})
+
+	It("should keep the previous files when an archive is refused", func() {
+		_, err := pull(config.Dependency{Name: "evil", Type: "proto", Version: "4.0.0"})
+		Expect(err).To(BeNil())
+
+		_, err = pull(config.Dependency{Name: "evil", Type: "proto", Version: "2.0.0"})
+		Expect(err).ToNot(BeNil())
+
+		b, err := ioutil.ReadFile(filepath.Join(directory, "evil", "proto", "evil.proto"))
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal("4.0.0"))
+
+		entries, err := ioutil.ReadDir(filepath.Join(directory, "evil"))
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Name()).To(Equal("proto"))
+	})
+
+	It("should unpack archives with absolute names inside the dependency directory", func() {
+		_, err := pull(config.Dependency{Name: "legacy", Type: "proto", Version: "1.0.0"})
+		Expect(err).To(BeNil())
+
+		_, err = os.Stat(filepath.Join(directory, "legacy", "proto", "legacy.proto"))
+		Expect(err).To(BeNil())
+	})
+
+	It("should report every failed dependency", func() {
+		_, err := client.Pull(client.PullOptions{
+			Configuration: &config.Configuration{
+				Name:         "consumer",
+				Repository:   server.URL,
+				IdlDirectory: directory,
+				Dependencies: []config.Dependency{
+					{Name: "evil", Type: "proto", Version: "1.0.0"},
+					{Name: "common", Type: "proto", Version: "1.0.0"},
+					{Name: "broken", Type: "proto", Version: "1.0.0"},
+				},
+			},
+			Jobs: 2,
+		})
+		Expect(err).ToNot(BeNil())
+
+		lines := strings.Split(err.Error(), "\n")
+		Expect(lines).To(HaveLen(3))
+		Expect(lines[0]).To(Equal("failed to pull 2 dependencies:"))
+		Expect(lines[1]).To(ContainSubstring("'../../../escaped.proto' of dependency 'evil/proto@1.0.0'"))
+		Expect(lines[2]).To(Equal("  failed to read dependency 'broken/proto@1.0.0': gzip: invalid header"))
+
+		_, err = os.Stat(filepath.Join(directory, "common", "proto", "common.proto"))
+		Expect(err).To(BeNil())
+	})
+
+	It("should pull offline from the cache", func() {
+		cacheDirectory, err := ioutil.TempDir("", "idl-cache")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(cacheDirectory)
+
+		options := client.PullOptions{
+			Configuration: &config.Configuration{
+				Name:         "consumer",
+				Repository:   server.URL,
+				IdlDirectory: directory,
+				Dependencies: []config.Dependency{{Name: "service", Type: "proto", Version: "^1.0.0"}},
+			},
+			Cache: cache.New(cacheDirectory),
+		}
+
+		options.Offline = true
+		_, err = client.Pull(options)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(Equal("no versions of dependency 'service' with type 'proto' are cached"))
+
+		options.Offline = false
+		online, err := client.Pull(options)
+		Expect(err).To(BeNil())
+
+		server.Close()
+		os.RemoveAll(directory)
+
+		options.Offline = true
+		offline, err := client.Pull(options)
+		Expect(err).To(BeNil())
+		Expect(offline).To(Equal(online))
+
+		b, err := ioutil.ReadFile(filepath.Join(directory, "common", "proto", "common.proto"))
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal("1.3.0"))
+	})
+
+	It("should warn about deprecated dependencies and fail when strict", func() {
+		warnings := []string{}
+		options := client.PullOptions{
+			Configuration: &config.Configuration{
+				Name:         "consumer",
+				Repository:   server.URL,
+				IdlDirectory: directory,
+				Dependencies: []config.Dependency{
+					{Name: "old", Type: "proto", Version: "1.0.0"},
+					{Name: "common", Type: "proto", Version: "1.0.0"},
+				},
+			},
+			Warn: func(message string) { warnings = append(warnings, message) },
+		}
+
+		_, err := client.Pull(options)
+		Expect(err).To(BeNil())
+		Expect(warnings).To(Equal([]string{
+			"dependency 'old/proto@1.0.0' is deprecated along with its project: no longer maintained, use 'example/v2' instead",
+		}))
+
+		options.Strict = true
+		_, err = client.Pull(options)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(Equal("dependency 'old/proto@1.0.0' is deprecated along with its project: no longer maintained, use 'example/v2' instead"))
+	})
+
+	It("should only send $IDL_TOKEN to the configured repository", func() {
+		recorded := func(seen *[]string, projects map[string]map[string]fakeVersion) *httptest.Server {
+			handler := fakeHandler(projects)
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				*seen = append(*seen, r.Header.Get("Authorization"))
+				handler.ServeHTTP(w, r)
+			}))
+		}
+
+		upstreamSeen := []string{}
+		upstream := recorded(&upstreamSeen, map[string]map[string]fakeVersion{
+			"common/proto": {"1.0.0": {files: map[string]string{"common.proto": "1.0.0"}}},
+		})
+		defer upstream.Close()
+
+		homeSeen := []string{}
+		home := recorded(&homeSeen, map[string]map[string]fakeVersion{
+			"service/proto": {"1.0.0": {
+				dependencies: []config.Dependency{{Name: "common", Type: "proto", Version: "^1.0.0", Repository: upstream.URL}},
+				files:        map[string]string{"service.proto": "v1"},
+			}},
+		})
+		defer home.Close()
+
+		os.Setenv("IDL_TOKEN", "secret")
+		defer os.Unsetenv("IDL_TOKEN")
+
+		_, err := client.Pull(client.PullOptions{
+			Configuration: &config.Configuration{
+				Name:         "consumer",
+				Repository:   home.URL,
+				IdlDirectory: directory,
+				Dependencies: []config.Dependency{{Name: "service", Type: "proto", Version: "^1.0.0"}},
+			},
+		})
+		Expect(err).To(BeNil())
+
+		Expect(homeSeen).ToNot(BeEmpty())
+		for _, authorization := range homeSeen {
+			Expect(authorization).To(Equal("Bearer secret"))
+		}
+		Expect(upstreamSeen).ToNot(BeEmpty())
+		for _, authorization := range upstreamSeen {
+			Expect(authorization).To(BeEmpty())
+		}
+	})
+
+	It("should trust the CA in $IDL_CA_CERT", func() {
+		secure := httptest.NewTLSServer(fakeHandler(map[string]map[string]fakeVersion{
+			"common/proto": {"1.0.0": {files: map[string]string{"common.proto": "1.0.0"}}},
+		}))
+		defer secure.Close()
+
+		options := client.PullOptions{
+			Configuration: &config.Configuration{
+				Name:         "consumer",
+				Repository:   secure.URL,
+				IdlDirectory: directory,
+				Dependencies: []config.Dependency{{Name: "common", Type: "proto", Version: "1.0.0"}},
+			},
+		}
+
+		_, err := client.Pull(options)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("certificate"))
+
+		ca := filepath.Join(directory, "ca.pem")
+		Expect(ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: secure.Certificate().Raw}), 0600)).To(Succeed())
+		os.Setenv("IDL_CA_CERT", ca)
+		defer os.Unsetenv("IDL_CA_CERT")
+
+		_, err = client.Pull(options)
+		Expect(err).To(BeNil())
+		Expect(filepath.Join(directory, "common", "proto", "common.proto")).To(BeAnExistingFile())
+	})
+
+	It("should only trust the CA in $IDL_CA_CERT for the configured repository", func() {
+		upstream := httptest.NewTLSServer(fakeHandler(map[string]map[string]fakeVersion{
+			"common/proto": {"1.0.0": {files: map[string]string{"common.proto": "1.0.0"}}},
+		}))
+		defer upstream.Close()
+
+		home := fakeRepository(map[string]map[string]fakeVersion{
+			"service/proto": {"1.0.0": {
+				dependencies: []config.Dependency{{Name: "common", Type: "proto", Version: "1.0.0", Repository: upstream.URL}},
+				files:        map[string]string{"service.proto": "v1"},
+			}},
+		})
+		defer home.Close()
+
+		ca := filepath.Join(directory, "ca.pem")
+		Expect(ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw}), 0600)).To(Succeed())
+		os.Setenv("IDL_CA_CERT", ca)
+		defer os.Unsetenv("IDL_CA_CERT")
+
+		_, err := client.Pull(client.PullOptions{
+			Configuration: &config.Configuration{
+				Name:         "consumer",
+				Repository:   home.URL,
+				IdlDirectory: directory,
+				Dependencies: []config.Dependency{{Name: "service", Type: "proto", Version: "1.0.0"}},
+			},
+		})
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("certificate"))
+	})
+
+	It("should show the error the repository describes", func() {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			w.Write([]byte(`{"code":"internal","message":"internal server error","request_id":"abc123"}`))
+		}))
+		defer failing.Close()
+
+		_, err := client.Pull(client.PullOptions{
+			Configuration: &config.Configuration{
+				Name:         "consumer",
+				Repository:   failing.URL,
+				IdlDirectory: directory,
+				Dependencies: []config.Dependency{{Name: "common", Type: "proto", Version: "^1.0.0"}},
+			},
+		})
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(Equal("failed listing versions of 'common' with type 'proto': internal server error (request id abc123)"))
+	})
+
+	It("should show the error the repository describes when deprecating", func() {
+		missing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(404)
+			w.Write([]byte(`{"code":"not_found","message":"project 'consumer' does not exist"}`))
+		}))
+		defer missing.Close()
+
+		options := client.DeprecateOptions{
+			Configuration: &config.Configuration{Name: "consumer", Repository: missing.URL},
+			Message:       "no longer maintained",
+		}
+
+		err := client.Deprecate(options)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(Equal("deprecating failed: project 'consumer' does not exist"))
+
+		err = client.Undeprecate(options)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(Equal("removing deprecation failed: project 'consumer' does not exist"))
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"bufio"
 	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -19,9 +20,13 @@ import (
 	"github.com/rs/xid"
 )
 
+// dependenciesHeader carries the json list of dependencies of an uploaded archive
+const dependenciesHeader = "X-Idl-Dependencies"
+
 type PushOptions struct {
 	Configuration *config.Configuration
 	Version       *semver.Version
+	Force         bool
 }
 
 func Push(options PushOptions) error {
@@ -48,18 +53,56 @@ func Push(options PushOptions) error {
 			provider.Type,
 			options.Version.String())
 
-		resp, err := http.Post(url, "", f)
+		query := ""
+		if options.Force {
+			query = "?force=true"
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url+query, f)
+		if err != nil {
+			return errors.Wrap(err, "failed creating request")
+		}
+
+		// dependencies travel with the archive so a version is never published without them
+		err = setDependencies(req.Header, options.Configuration)
+		if err != nil {
+			return err
+		}
+
+		resp, err := do(options.Configuration.Repository, req)
 		if err != nil {
 			return errors.Wrap(err, "failed posting module to registry")
 		}
+		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusCreated {
-			return errors.New("upload failed")
+			return errors.Wrapf(responseError(resp), "upload of type '%s' failed", provider.Type)
 		}
 	}
 	return nil
 }
 
+// setDependencies records the project's dependencies with the pushed version so pulls can resolve them transitively
+func setDependencies(header http.Header, configuration *config.Configuration) error {
+	if len(configuration.Dependencies) < 1 {
+		return nil
+	}
+
+	dependencies := []config.Dependency{}
+	for _, dependency := range configuration.Dependencies {
+		dependency.Repository = configuration.ResolveRepository(dependency)
+		dependencies = append(dependencies, dependency)
+	}
+
+	b, err := json.Marshal(dependencies)
+	if err != nil {
+		return errors.Wrap(err, "failed encoding dependencies")
+	}
+
+	header.Set(dependenciesHeader, string(b))
+	return nil
+}
+
 func getExcludes(idlIgnore string) []string {
 	if idlIgnore == "" {
 		excludes, err := readIdlIgnoreFile(".idlignore")
@@ -147,18 +190,22 @@ func gzipRoot(root string, excludes []string) (string, error) {
 			return nil
 		}
 
+		if file == root {
+			return nil
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return errors.New(fmt.Sprintf("'%s' is a symbolic link, only files and directories can be pushed", file))
+		}
+
 		// create a new dir/file header
 		header, err := tar.FileInfoHeader(fi, fi.Name())
 		if err != nil {
 			return err
 		}
 
-		if file == root {
-			return nil
-		}
-
 		// update the name to correctly reflect the desired destination when untaring
-		header.Name = strings.TrimPrefix(file, cleanRoot)
+		header.Name = filepath.ToSlash(relFile)
 
 		// write the header
 		if err := tw.WriteHeader(header); err != nil {
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	caVariable         = "IDL_CA_CERT"
+	clientCertVariable = "IDL_CLIENT_CERT"
+	clientKeyVariable  = "IDL_CLIENT_KEY"
+)
+
+type tlsFiles struct {
+	ca   string
+	cert string
+	key  string
+}
+
+var (
+	clientsLock sync.Mutex
+	clients     = map[tlsFiles]*http.Client{}
+)
+
+// tlsFilesFor finds the CA and client certificate for a repository url. Like $IDL_TOKEN,
+// the environment only applies to home, the repository of idl.yaml, and wins over the
+// credentials file there. Repositories of dependencies only use the credentials file.
+func tlsFilesFor(home string, url string) tlsFiles {
+	credential := credentialFor(url)
+	files := tlsFiles{credential.CA, credential.Cert, credential.Key}
+
+	if home == "" || !onRepository(home, url) {
+		return files
+	}
+
+	if ca := os.Getenv(caVariable); ca != "" {
+		files.ca = ca
+	}
+	if cert := os.Getenv(clientCertVariable); cert != "" {
+		files.cert = cert
+		files.key = os.Getenv(clientKeyVariable)
+	}
+	return files
+}
+
+// clientFor returns a client trusting the repository's CA and presenting its client certificate
+func clientFor(home string, url string) (*http.Client, error) {
+	files := tlsFilesFor(home, url)
+	if files == (tlsFiles{}) {
+		return http.DefaultClient, nil
+	}
+
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+
+	client, ok := clients[files]
+	if ok {
+		return client, nil
+	}
+
+	config, err := files.config()
+	if err != nil {
+		return nil, err
+	}
+
+	client = &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig:     config,
+			TLSHandshakeTimeout: 10 * time.Second,
+			IdleConnTimeout:     90 * time.Second,
+			MaxIdleConns:        100,
+		},
+	}
+	clients[files] = client
+	return client, nil
+}
+
+func (f tlsFiles) config() (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if f.ca != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		b, err := ioutil.ReadFile(f.ca)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read CA certificate")
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.New(fmt.Sprintf("CA certificate '%s' does not contain any certificates", f.ca))
+		}
+		config.RootCAs = pool
+	}
+
+	if f.cert != "" || f.key != "" {
+		if f.cert == "" || f.key == "" {
+			return nil, errors.New("a client certificate needs both a certificate and a key")
+		}
+
+		certificate, err := tls.LoadX509KeyPair(f.cert, f.key)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load client certificate")
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/syncromatics/idl-repository/pkg/constraint"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/pkg/errors"
+)
+
+// satisfies checks if a version previously resolved still matches the requested version
+func satisfies(requested string, version string) bool {
+	if constraint.IsExact(requested) {
+		return requested == version
+	}
+
+	c, err := constraint.Parse(requested)
+	if err != nil {
+		return false
+	}
+
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+
+	return c.Check(v)
+}
+
+func listVersions(home string, repository string, name string, idlType string) ([]string, error) {
+	path := fmt.Sprintf("%s/v1/projects/%s/types/%s/versions", repository, name, idlType)
+
+	resp, err := get(home, path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed listing versions")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Wrapf(responseError(resp), "failed listing versions of '%s' with type '%s'", name, idlType)
+	}
+
+	// older repositories list plain version strings
+	listed := []json.RawMessage{}
+	err = json.NewDecoder(resp.Body).Decode(&listed)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed decoding versions")
+	}
+
+	versions := []string{}
+	for _, raw := range listed {
+		info := struct {
+			Version string `json:"version"`
+		}{}
+		err = json.Unmarshal(raw, &info.Version)
+		if err != nil {
+			err = json.Unmarshal(raw, &info)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed decoding versions")
+		}
+
+		versions = append(versions, info.Version)
+	}
+
+	return versions, nil
+}
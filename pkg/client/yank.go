@@ -0,0 +1,90 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/syncromatics/idl-repository/pkg/config"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/pkg/errors"
+)
+
+type YankOptions struct {
+	Configuration *config.Configuration
+	Version       *semver.Version
+	// Type limits the change to one type, otherwise every provided type is changed
+	Type   string
+	Reason string
+}
+
+// Yank hides a version from version ranges, lock files that pin it can still pull it
+func Yank(options YankOptions) error {
+	b, err := json.Marshal(struct {
+		Reason string `json:"reason,omitempty"`
+	}{options.Reason})
+	if err != nil {
+		return errors.Wrap(err, "failed encoding yank request")
+	}
+
+	return eachType(options, func(url string, idlType string) error {
+		resp, err := put(options.Configuration.Repository, url, "application/json", bytes.NewReader(b))
+		if err != nil {
+			return errors.Wrap(err, "failed yanking version")
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return errors.Wrapf(responseError(resp), "yanking type '%s' failed", idlType)
+		}
+		return nil
+	})
+}
+
+// Unyank makes a yanked version available to version ranges again
+func Unyank(options YankOptions) error {
+	return eachType(options, func(url string, idlType string) error {
+		resp, err := del(options.Configuration.Repository, url)
+		if err != nil {
+			return errors.Wrap(err, "failed unyanking version")
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return errors.Wrapf(responseError(resp), "unyanking type '%s' failed", idlType)
+		}
+		return nil
+	})
+}
+
+func eachType(options YankOptions, change func(url string, idlType string) error) error {
+	types := []string{}
+	if options.Type != "" {
+		types = append(types, options.Type)
+	}
+	for _, provider := range options.Configuration.Provides {
+		if options.Type == "" {
+			types = append(types, provider.Type)
+		}
+	}
+
+	if len(types) < 1 {
+		return errors.New("the project does not provide any types, pick one with --type")
+	}
+
+	for _, idlType := range types {
+		url := fmt.Sprintf("%s/v1/projects/%s/types/%s/versions/%s/yank",
+			options.Configuration.Repository,
+			options.Configuration.Name,
+			idlType,
+			options.Version.String())
+
+		err := change(url, idlType)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
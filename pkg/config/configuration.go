@@ -17,10 +17,10 @@ type Configuration struct {
 }
 
 type Dependency struct {
-	Name       string `yaml:"name"`
-	Version    string `yaml:"version"`
-	Type       string `yaml:"type"`
-	Repository string `yaml:"repository"`
+	Name       string `yaml:"name" json:"name"`
+	Version    string `yaml:"version" json:"version"`
+	Type       string `yaml:"type" json:"type"`
+	Repository string `yaml:"repository" json:"repository"`
 }
 
 type Provide struct {
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+type Lock struct {
+	Dependencies []LockedDependency `yaml:"dependencies"`
+}
+
+type LockedDependency struct {
+	Name       string `yaml:"name"`
+	Type       string `yaml:"type"`
+	Version    string `yaml:"version"`
+	Repository string `yaml:"repository"`
+	Digest     string `yaml:"digest"`
+}
+
+func (l *Lock) Marshal(writer io.Writer) error {
+	en := yaml.NewEncoder(writer)
+	defer en.Close()
+
+	err := en.Encode(l)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode lock")
+	}
+
+	return nil
+}
+
+func (l *Lock) UnMarshal(reader io.Reader) error {
+	d := yaml.NewDecoder(reader)
+
+	err := d.Decode(l)
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "failed to decode lock")
+	}
+
+	return nil
+}
+
+func (l *Lock) Find(name string, idlType string) (LockedDependency, bool) {
+	if l == nil {
+		return LockedDependency{}, false
+	}
+
+	for _, locked := range l.Dependencies {
+		if locked.Name == name && locked.Type == idlType {
+			return locked, true
+		}
+	}
+
+	return LockedDependency{}, false
+}
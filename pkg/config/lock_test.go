@@ -0,0 +1,76 @@
+package config_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/syncromatics/idl-repository/pkg/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lock", func() {
+	Context("marshalling lock to text", func() {
+		lock := &config.Lock{
+			Dependencies: []config.LockedDependency{
+				config.LockedDependency{
+					Name:       "dependency1",
+					Type:       "protobuf",
+					Version:    "1.4.2",
+					Repository: "http://first.example.com",
+					Digest:     "sha256:abc123",
+				},
+			},
+		}
+
+		text := new(bytes.Buffer)
+
+		err := lock.Marshal(text)
+
+		It("should not error", func() {
+			Expect(err).To(BeNil())
+		})
+
+		It("should create readable text", func() {
+			expect := `dependencies:
+- name: dependency1
+  type: protobuf
+  version: 1.4.2
+  repository: http://first.example.com
+  digest: sha256:abc123
+`
+
+			Expect(text.String()).To(Equal(expect))
+		})
+	})
+
+	Context("unmarshalling from text", func() {
+		text := `
+dependencies:
+- name: dependency1
+  type: protobuf
+  version: 1.4.2
+  repository: http://first.example.com
+  digest: sha256:abc123
+`
+
+		lock := new(config.Lock)
+
+		err := lock.UnMarshal(strings.NewReader(text))
+
+		It("should not error", func() {
+			Expect(err).To(BeNil())
+		})
+
+		It("should find locked dependencies", func() {
+			locked, ok := lock.Find("dependency1", "protobuf")
+			Expect(ok).To(BeTrue())
+			Expect(locked.Version).To(Equal("1.4.2"))
+			Expect(locked.Digest).To(Equal("sha256:abc123"))
+
+			_, ok = lock.Find("dependency1", "avro")
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,311 @@
+package constraint
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/pkg/errors"
+)
+
+// Constraint is a semantic version range such as "^1.2.0", "~2.1" or ">=1.0 <2.0".
+// Ranges separated by "||" are alternatives.
+type Constraint struct {
+	raw  string
+	sets [][]comparator
+}
+
+type comparator struct {
+	op      string
+	version semver.Version
+}
+
+func Parse(text string) (*Constraint, error) {
+	c := &Constraint{raw: strings.TrimSpace(text)}
+
+	for _, part := range strings.Split(c.raw, "||") {
+		set, err := parseSet(part)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid version constraint '%s'", text)
+		}
+		c.sets = append(c.sets, set)
+	}
+
+	return c, nil
+}
+
+// IsExact returns true when the text is a single version and not a range.
+func IsExact(text string) bool {
+	_, err := semver.NewVersion(strings.TrimSpace(text))
+	return err == nil
+}
+
+func (c *Constraint) String() string {
+	return c.raw
+}
+
+func (c *Constraint) Check(version *semver.Version) bool {
+	for _, set := range c.sets {
+		if checkSet(set, version) {
+			return true
+		}
+	}
+	return false
+}
+
+// Highest returns the highest of the versions that satisfies the constraint.
+// Versions that are not valid semantic versions are ignored.
+func (c *Constraint) Highest(versions []string) (string, bool) {
+	var best *semver.Version
+	found := ""
+	for _, v := range versions {
+		version, err := semver.NewVersion(v)
+		if err != nil {
+			continue
+		}
+
+		if !c.Check(version) {
+			continue
+		}
+
+		if best == nil || best.LessThan(*version) {
+			best = version
+			found = v
+		}
+	}
+
+	return found, best != nil
+}
+
+func checkSet(set []comparator, version *semver.Version) bool {
+	for _, c := range set {
+		if !c.check(version) {
+			return false
+		}
+	}
+
+	if version.PreRelease == "" {
+		return true
+	}
+
+	// pre-releases only match when the range explicitly mentions a pre-release of the same version
+	for _, c := range set {
+		if c.version.PreRelease != "" &&
+			c.version.Major == version.Major &&
+			c.version.Minor == version.Minor &&
+			c.version.Patch == version.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+func (c comparator) check(version *semver.Version) bool {
+	cmp := version.Compare(c.version)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+func parseSet(text string) ([]comparator, error) {
+	fields := strings.Fields(strings.Replace(text, ",", " ", -1))
+
+	// join operators separated from their version, e.g. ">= 1.0"
+	tokens := []string{}
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if isOperator(f) && i+1 < len(fields) {
+			f += fields[i+1]
+			i++
+		}
+		tokens = append(tokens, f)
+	}
+
+	// hyphen ranges, e.g. "1.2 - 1.4"
+	if len(tokens) == 3 && tokens[1] == "-" {
+		lower, err := expand(">=" + tokens[0])
+		if err != nil {
+			return nil, err
+		}
+		upper, err := expand("<=" + tokens[2])
+		if err != nil {
+			return nil, err
+		}
+		return append(lower, upper...), nil
+	}
+
+	set := []comparator{}
+	for _, token := range tokens {
+		comparators, err := expand(token)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, comparators...)
+	}
+	return set, nil
+}
+
+func isOperator(text string) bool {
+	switch text {
+	case "=", "!=", ">", ">=", "<", "<=", "^", "~":
+		return true
+	}
+	return false
+}
+
+func expand(token string) ([]comparator, error) {
+	op := ""
+	for _, candidate := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(token, candidate) {
+			op = candidate
+			break
+		}
+	}
+
+	version, parts, err := parsePartial(strings.TrimPrefix(token, op))
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "", "=":
+		switch parts {
+		case 0:
+			return []comparator{}, nil
+		case 3:
+			return []comparator{{"=", version}}, nil
+		}
+		return between(version, bump(version, parts)), nil
+
+	case "!=":
+		if parts != 3 {
+			return nil, errors.New(fmt.Sprintf("'%s' requires a full version", token))
+		}
+		return []comparator{{"!=", version}}, nil
+
+	case "^":
+		switch {
+		case parts == 0:
+			return []comparator{}, nil
+		case version.Major > 0 || parts == 1:
+			return between(version, bump(version, 1)), nil
+		case version.Minor > 0 || parts == 2:
+			return between(version, bump(version, 2)), nil
+		}
+		return between(version, bump(version, 3)), nil
+
+	case "~":
+		switch parts {
+		case 0:
+			return []comparator{}, nil
+		case 1:
+			return between(version, bump(version, 1)), nil
+		}
+		return between(version, bump(version, 2)), nil
+
+	case ">":
+		switch parts {
+		case 0:
+			return nil, errors.New(fmt.Sprintf("'%s' matches nothing", token))
+		case 3:
+			return []comparator{{">", version}}, nil
+		}
+		return []comparator{{">=", bump(version, parts)}}, nil
+
+	case ">=":
+		return []comparator{{">=", version}}, nil
+
+	case "<":
+		if parts == 0 {
+			return nil, errors.New(fmt.Sprintf("'%s' matches nothing", token))
+		}
+		return []comparator{{"<", version}}, nil
+
+	case "<=":
+		switch parts {
+		case 0:
+			return []comparator{}, nil
+		case 3:
+			return []comparator{{"<=", version}}, nil
+		}
+		return []comparator{{"<", bump(version, parts)}}, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("unknown operator in '%s'", token))
+}
+
+func between(lower semver.Version, upper semver.Version) []comparator {
+	return []comparator{{">=", lower}, {"<", upper}}
+}
+
+// bump increments the last specified part of a partial version and zeros the rest
+func bump(version semver.Version, parts int) semver.Version {
+	switch parts {
+	case 1:
+		return semver.Version{Major: version.Major + 1}
+	case 2:
+		return semver.Version{Major: version.Major, Minor: version.Minor + 1}
+	}
+	return semver.Version{Major: version.Major, Minor: version.Minor, Patch: version.Patch + 1}
+}
+
+// parsePartial parses versions like "1", "1.2", "1.2.x" or "1.2.3-beta" and
+// returns how many of the major, minor and patch parts were specified.
+func parsePartial(text string) (semver.Version, int, error) {
+	text = strings.TrimPrefix(text, "v")
+	if text == "" {
+		return semver.Version{}, 0, errors.New("missing version")
+	}
+
+	if full, err := semver.NewVersion(text); err == nil {
+		return *full, 3, nil
+	}
+
+	if strings.ContainsAny(text, "-+") {
+		return semver.Version{}, 0, errors.New(fmt.Sprintf("'%s' is not a valid version", text))
+	}
+
+	numbers := []int64{}
+	for _, part := range strings.Split(text, ".") {
+		if part == "*" || part == "x" || part == "X" {
+			break
+		}
+
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || n < 0 {
+			return semver.Version{}, 0, errors.New(fmt.Sprintf("'%s' is not a valid version", text))
+		}
+		numbers = append(numbers, n)
+	}
+
+	if len(numbers) > 3 {
+		return semver.Version{}, 0, errors.New(fmt.Sprintf("'%s' is not a valid version", text))
+	}
+
+	version := semver.Version{}
+	for i, n := range numbers {
+		switch i {
+		case 0:
+			version.Major = n
+		case 1:
+			version.Minor = n
+		case 2:
+			version.Patch = n
+		}
+	}
+
+	return version, len(numbers), nil
+}
@@ -0,0 +1,13 @@
+package constraint_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestConstraint(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Constraint Suite")
+}
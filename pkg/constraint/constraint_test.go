@@ -0,0 +1,79 @@
+package constraint_test
+
+import (
+	"github.com/coreos/go-semver/semver"
+	"github.com/syncromatics/idl-repository/pkg/constraint"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Constraint", func() {
+	DescribeTable("checking versions",
+		func(text string, version string, expected bool) {
+			c, err := constraint.Parse(text)
+			Expect(err).To(BeNil())
+			Expect(c.Check(semver.New(version))).To(Equal(expected))
+		},
+		Entry("exact match", "1.2.3", "1.2.3", true),
+		Entry("exact mismatch", "1.2.3", "1.2.4", false),
+		Entry("caret allows minor", "^1.2.0", "1.9.0", true),
+		Entry("caret rejects major", "^1.2.0", "2.0.0", false),
+		Entry("caret rejects lower", "^1.2.0", "1.1.9", false),
+		Entry("caret zero major", "^0.2.1", "0.3.0", false),
+		Entry("caret zero minor", "^0.0.3", "0.0.4", false),
+		Entry("tilde allows patch", "~2.1", "2.1.7", true),
+		Entry("tilde rejects minor", "~2.1", "2.2.0", false),
+		Entry("range inside", ">=1.0 <2.0", "1.5.0", true),
+		Entry("range upper bound", ">=1.0 <2.0", "2.0.0", false),
+		Entry("separated operator", ">= 1.0, < 2.0", "1.0.0", true),
+		Entry("wildcard", "1.x", "1.4.2", true),
+		Entry("wildcard mismatch", "1.x", "2.0.0", false),
+		Entry("star", "*", "9.9.9", true),
+		Entry("hyphen range", "1.2 - 1.4", "1.4.9", true),
+		Entry("hyphen range upper", "1.2 - 1.4", "1.5.0", false),
+		Entry("alternatives", "^1.0.0 || ^3.0.0", "3.1.0", true),
+		Entry("alternatives mismatch", "^1.0.0 || ^3.0.0", "2.1.0", false),
+		Entry("excluded version", "^1.0.0 !=1.2.0", "1.2.0", false),
+		Entry("pre-release excluded", "^1.0.0", "1.3.0-beta", false),
+		Entry("pre-release of mentioned version", "^1.3.0-alpha", "1.3.0-beta", true),
+	)
+
+	Context("picking the highest version", func() {
+		c, _ := constraint.Parse("^1.2.0")
+
+		version, ok := c.Highest([]string{"1.1.0", "1.3.0", "1.10.1", "2.0.0", "not-a-version", "1.11.0-rc1"})
+
+		It("should find the highest match", func() {
+			Expect(ok).To(BeTrue())
+			Expect(version).To(Equal("1.10.1"))
+		})
+	})
+
+	Context("picking from versions that do not match", func() {
+		c, _ := constraint.Parse("^3.0.0")
+
+		_, ok := c.Highest([]string{"1.1.0", "2.0.0"})
+
+		It("should not find a version", func() {
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("parsing invalid text", func() {
+		_, err := constraint.Parse("^one.two")
+
+		It("should error", func() {
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Context("detecting exact versions", func() {
+		It("should detect exact versions", func() {
+			Expect(constraint.IsExact("1.2.3")).To(BeTrue())
+			Expect(constraint.IsExact("^1.2.3")).To(BeFalse())
+			Expect(constraint.IsExact("1.2")).To(BeFalse())
+		})
+	})
+})